@@ -0,0 +1,565 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mproffitt/importmanager/pkg/checksum"
+	c "github.com/mproffitt/importmanager/pkg/config"
+	h "github.com/mproffitt/importmanager/pkg/handler"
+	"github.com/mproffitt/importmanager/pkg/index"
+	"github.com/mproffitt/importmanager/pkg/journal"
+	"github.com/mproffitt/importmanager/pkg/scenario"
+)
+
+type command func(args []string) error
+
+var commands = map[string]command{
+	"dupes":   dupes,
+	"status":  status,
+	"retry":   retry,
+	"config":  configCommand,
+	"schema":  schema,
+	"paths":   paths,
+	"summary": summary,
+	"test":    test,
+	"replay":  replay,
+}
+
+var configCommands = map[string]command{
+	"restore": restore,
+	"migrate": migrate,
+}
+
+// runCommand Execute the named sub-command
+func runCommand(name string, args []string) (err error) {
+	var cmd, ok = commands[name]
+	if !ok {
+		return fmt.Errorf("unknown command '%s'", name)
+	}
+	return cmd(args)
+}
+
+// loadConfig Parse the common flags for a sub-command and load the config file
+func loadConfig(flags *flag.FlagSet, args []string) (config *c.Config, err error) {
+	var filename string
+	flags.StringVar(&filename, "config", "", "Path to config file. Defaults to "+c.DefaultConfigFile)
+	if err = flags.Parse(args); err != nil {
+		return
+	}
+	filename = c.ConfigFile(filename)
+	if _, err = os.Stat(filename); err != nil {
+		err = fmt.Errorf("config file %s does not exist", filename)
+		return
+	}
+	config, err = c.New(filename, h.Handle)
+	return
+}
+
+// dupes List files with the same content across each indexed root
+func dupes(args []string) (err error) {
+	var (
+		flags  *flag.FlagSet = flag.NewFlagSet("dupes", flag.ExitOnError)
+		config *c.Config
+	)
+	if config, err = loadConfig(flags, args); err != nil {
+		return
+	}
+	if !config.ContentIndex.Enabled {
+		return fmt.Errorf("contentIndex is not enabled in the config file")
+	}
+
+	if err = checksum.Setup(config.CacheDirectory, config.HashAlgorithm); err != nil {
+		return
+	}
+	defer checksum.Save()
+
+	for _, i := range index.Load(config) {
+		if err = i.Scan(); err != nil {
+			return
+		}
+		if err = i.Save(); err != nil {
+			return
+		}
+
+		var groups [][]string = i.Duplicates()
+		fmt.Printf("%s: %d duplicate sets\n", i.Root, len(groups))
+		for _, group := range groups {
+			sort.Strings(group)
+			fmt.Println()
+			for _, path := range group {
+				fmt.Printf("  %s\n", path)
+			}
+		}
+	}
+	return
+}
+
+// status Show the queue depth and active workers of a running instance
+func status(args []string) (err error) {
+	var (
+		flags  *flag.FlagSet = flag.NewFlagSet("status", flag.ExitOnError)
+		config *c.Config
+		s      h.Status
+	)
+	if config, err = loadConfig(flags, args); err != nil {
+		return
+	}
+	if s, err = h.ReadStatus(config.StateDirectory); err != nil {
+		return fmt.Errorf("unable to read status. Is importmanager running? %s", err.Error())
+	}
+
+	fmt.Printf("Updated: %s ago\n", time.Since(s.Updated).Round(time.Second))
+	fmt.Printf("Workers: %d/%d active, %d queued, %d retrying\n", s.Active, s.Workers, s.Queued, s.Retrying)
+
+	var paths []string = make([]string, 0, len(s.Paths))
+	for path := range s.Paths {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		var ps h.PathStatus = s.Paths[path]
+		if ps.State == "" {
+			ps.State = h.PathActive
+		}
+		fmt.Printf("  %s (%s): %d active, %d queued\n", path, ps.State, ps.Active, ps.Queued)
+	}
+	for handler, n := range s.Handlers {
+		fmt.Printf("  handler %s: %d active\n", handler, n)
+	}
+	return
+}
+
+// retry List quarantined files or move them back to be handled again
+//
+// With no arguments every quarantined file is released. Otherwise only
+// files whose name or original path matches one of the arguments are.
+func retry(args []string) (err error) {
+	var (
+		flags  *flag.FlagSet = flag.NewFlagSet("retry", flag.ExitOnError)
+		list   *bool         = flags.Bool("list", false, "List quarantined files without releasing them")
+		config *c.Config
+		files  []h.Quarantined
+	)
+	if config, err = loadConfig(flags, args); err != nil {
+		return
+	}
+	if files, err = h.ListQuarantine(config.Quarantine); err != nil {
+		return
+	}
+
+	for _, q := range files {
+		if flags.NArg() > 0 && !matches(q, flags.Args()) {
+			continue
+		}
+		if *list {
+			fmt.Printf("%s\n  from:     %s\n  attempts: %d\n  error:    %s\n", q.Path, q.Source, q.Attempts, q.Error)
+			continue
+		}
+
+		var final string
+		if final, err = h.Release(q); err != nil {
+			return fmt.Errorf("unable to release %s - %s", q.Path, err.Error())
+		}
+		fmt.Printf("%s -> %s\n", q.Path, final)
+	}
+	return
+}
+
+func matches(q h.Quarantined, patterns []string) bool {
+	for _, pattern := range patterns {
+		if q.Path == pattern || q.Source == pattern || filepath.Base(q.Path) == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// configCommand Execute one of the `config` sub-commands
+func configCommand(args []string) (err error) {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: config <restore|migrate> [flags]")
+	}
+	var cmd, ok = configCommands[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown config command '%s'", args[0])
+	}
+	return cmd(args[1:])
+}
+
+// restore List the backups of a config file or replace it with one
+//
+// With no arguments the newest backup is restored. Otherwise the backup
+// with the given name or path is. The state directory is read from the
+// config file if it can still be loaded.
+func restore(args []string) (err error) {
+	var (
+		flags    *flag.FlagSet = flag.NewFlagSet("config restore", flag.ExitOnError)
+		filename *string       = flags.String("config", "", "Path to config file. Defaults to "+c.DefaultConfigFile)
+		state    *string       = flags.String("state", "", "State directory the backups were written to")
+		list     *bool         = flags.Bool("list", false, "List backups without restoring one")
+		backups  []string
+	)
+	if err = flags.Parse(args); err != nil {
+		return
+	}
+	*filename = c.ConfigFile(*filename)
+	if *state == "" {
+		*state = c.DefaultStateDirectory
+		if config, err := c.New(*filename, h.Handle); err == nil {
+			*state = config.StateDirectory
+		}
+	}
+
+	if backups, err = c.Backups(*state, *filename); err != nil {
+		return
+	}
+	if len(backups) == 0 {
+		return fmt.Errorf("no backups of %s found in %s", *filename, *state)
+	}
+	if *list {
+		for _, b := range backups {
+			fmt.Println(b)
+		}
+		return
+	}
+
+	var backup string = backups[0]
+	if flags.NArg() > 0 {
+		backup = ""
+		for _, b := range backups {
+			if b == flags.Arg(0) || filepath.Base(b) == flags.Arg(0) {
+				backup = b
+				break
+			}
+		}
+		if backup == "" {
+			return fmt.Errorf("no backup named %s", flags.Arg(0))
+		}
+	}
+	if err = c.Restore(backup, *filename); err != nil {
+		return
+	}
+	fmt.Printf("%s -> %s\n", backup, *filename)
+	return
+}
+
+// migrate Upgrade a config file and every file it includes to the current
+// version, keeping comments
+func migrate(args []string) (err error) {
+	var (
+		flags    *flag.FlagSet = flag.NewFlagSet("config migrate", flag.ExitOnError)
+		filename *string       = flags.String("config", "", "Path to config file. Defaults to "+c.DefaultConfigFile)
+		dryRun   *bool         = flags.Bool("dry-run", false, "Print the upgraded files without changing them")
+		sources  []string
+	)
+	if err = flags.Parse(args); err != nil {
+		return
+	}
+	*filename = c.ConfigFile(*filename)
+	if sources, err = c.Sources(*filename); err != nil {
+		return
+	}
+
+	for _, source := range sources {
+		upgraded, changes, outdated, err := c.MigrateFile(source, !*dryRun)
+		if err != nil {
+			return err
+		}
+		if !outdated {
+			fmt.Printf("%s: up to date\n", source)
+			continue
+		}
+		fmt.Printf("%s: upgraded to version %d\n", source, c.CurrentVersion)
+		for _, change := range changes {
+			fmt.Printf("  %s\n", change)
+		}
+		if *dryRun {
+			fmt.Printf("\n%s\n", upgraded)
+		}
+	}
+	return
+}
+
+// schema Print a JSON Schema for the config file
+//
+// Plugins in the plugin search path, or the given directory, which declare
+// their properties are included.
+func schema(args []string) (err error) {
+	var (
+		flags    *flag.FlagSet = flag.NewFlagSet("schema", flag.ExitOnError)
+		filename *string       = flags.String("config", "", "Path to config file. Defaults to "+c.DefaultConfigFile)
+		plugins  *string       = flags.String("plugins", "", "Plugin directory searched before the defaults")
+		output   []byte
+	)
+	if err = flags.Parse(args); err != nil {
+		return
+	}
+	if output, err = c.JSONSchema(c.PluginSearchPath(*plugins, *filename)); err != nil {
+		return
+	}
+	fmt.Println(string(output))
+	return
+}
+
+// paths Print the files and directories used by importmanager
+//
+// The locations are read from the config file. If it cannot be loaded the
+// defaults are shown.
+func paths(args []string) (err error) {
+	var (
+		flags    *flag.FlagSet = flag.NewFlagSet("paths", flag.ExitOnError)
+		filename *string       = flags.String("config", "", "Path to config file. Defaults to "+c.DefaultConfigFile)
+		config   *c.Config
+	)
+	if err = flags.Parse(args); err != nil {
+		return
+	}
+	*filename = c.ConfigFile(*filename)
+	if config, err = c.New(*filename, h.Handle); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to load %s, showing defaults - %s\n", *filename, err.Error())
+		config, err = c.Defaults(*filename), nil
+	}
+
+	var locations = [][2]string{
+		{"config", *filename},
+		{"fragments", filepath.Join(filepath.Dir(*filename), c.FragmentDirectory)},
+		{"state", config.StateDirectory},
+		{"journal", filepath.Join(config.StateDirectory, journal.FileName)},
+		{"quarantine", config.Quarantine},
+		{"backups", filepath.Join(config.StateDirectory, c.BackupDirectory)},
+		{"cache", config.CacheDirectory},
+	}
+	for i, dir := range config.PluginDirectories() {
+		var name string
+		if i == 0 {
+			name = "plugins"
+		}
+		locations = append(locations, [2]string{name, dir})
+	}
+	for _, l := range locations {
+		var missing string
+		if _, err := os.Stat(l[1]); err != nil {
+			missing = " (missing)"
+		}
+		fmt.Printf("%-11s %s%s\n", l[0], l[1], missing)
+	}
+	return
+}
+
+// summary Report what paths in observe mode would have done
+//
+// Observed jobs are read from the journal and listed by watched path,
+// followed by the number of files for each handler.
+func summary(args []string) (err error) {
+	var (
+		flags   *flag.FlagSet = flag.NewFlagSet("summary", flag.ExitOnError)
+		since   *string       = flags.String("since", "24h", "How far back to report, e.g. 12h or 7d")
+		config  *c.Config
+		window  time.Duration
+		records []journal.Record
+	)
+	if config, err = loadConfig(flags, args); err != nil {
+		return
+	}
+	if window, err = parseAge(*since); err != nil {
+		return
+	}
+	var start time.Time = time.Now().Add(-window)
+	if records, err = journal.History(config.StateDirectory, start); err != nil {
+		return
+	}
+
+	var (
+		watched []string                    = make([]string, 0)
+		byPath  map[string][]journal.Record = make(map[string][]journal.Record)
+		counts  map[string]int              = make(map[string]int)
+		total   int
+	)
+	for _, r := range records {
+		if r.State != journal.Observed {
+			continue
+		}
+		if _, ok := byPath[r.Watched]; !ok {
+			watched = append(watched, r.Watched)
+		}
+		byPath[r.Watched] = append(byPath[r.Watched], r)
+
+		var handler string = "delete"
+		if r.Processor != nil {
+			handler = filepath.Base(r.Processor.Handler)
+		}
+		if r.Error != "" {
+			handler = "failed"
+		}
+		counts[handler]++
+		total++
+	}
+	if total == 0 {
+		fmt.Printf("Nothing observed since %s\n", start.Format(time.RFC1123))
+		return
+	}
+
+	sort.Strings(watched)
+	fmt.Printf("Observed since %s\n", start.Format(time.RFC1123))
+	for _, w := range watched {
+		fmt.Printf("\n%s\n", w)
+		for _, r := range byPath[w] {
+			fmt.Printf("  %s\n", h.Describe(r.Path, r.Processor, r.Destination, r.Error))
+		}
+	}
+
+	var handlers []string = make([]string, 0, len(counts))
+	for handler, n := range counts {
+		handlers = append(handlers, fmt.Sprintf("%s %d", handler, n))
+	}
+	sort.Strings(handlers)
+	fmt.Printf("\n%d files: %s\n", total, strings.Join(handlers, ", "))
+	return
+}
+
+// replay Show how a config file would have handled the files in the journal
+//
+// Files which would be handled differently are listed with what happened
+// and what would happen now. Nothing is written.
+func replay(args []string) (err error) {
+	var (
+		flags   *flag.FlagSet = flag.NewFlagSet("replay", flag.ExitOnError)
+		since   *string       = flags.String("since", "30d", "How far back to replay, e.g. 12h or 30d")
+		state   *string       = flags.String("state", "", "State directory holding the journal. Defaults to that of the config file")
+		all     *bool         = flags.Bool("all", false, "Also list files which would be handled the same")
+		config  *c.Config
+		window  time.Duration
+		records []journal.Record
+	)
+	if config, err = loadConfig(flags, args); err != nil {
+		return
+	}
+	if window, err = parseAge(*since); err != nil {
+		return
+	}
+	if *state == "" {
+		*state = config.StateDirectory
+	}
+	var start time.Time = time.Now().Add(-window)
+	if records, err = journal.History(*state, start); err != nil {
+		return
+	}
+
+	var (
+		watched   []string                = make([]string, 0)
+		byPath    map[string][]h.Replayed = make(map[string][]h.Replayed)
+		unchanged int
+		changed   int
+		failed    int
+	)
+	for _, r := range records {
+		replayed, ok := h.Replay(r, config)
+		if !ok {
+			continue
+		}
+		switch {
+		case replayed.Error != "":
+			failed++
+		case replayed.Changed:
+			changed++
+		default:
+			unchanged++
+			if !*all {
+				continue
+			}
+		}
+		if _, ok := byPath[replayed.Watched]; !ok {
+			watched = append(watched, replayed.Watched)
+		}
+		byPath[replayed.Watched] = append(byPath[replayed.Watched], replayed)
+	}
+	if changed+unchanged+failed == 0 {
+		fmt.Printf("Nothing handled since %s\n", start.Format(time.RFC1123))
+		return
+	}
+
+	sort.Strings(watched)
+	fmt.Printf("Replaying files handled since %s\n", start.Format(time.RFC1123))
+	for _, w := range watched {
+		fmt.Printf("\n%s\n", w)
+		for _, r := range byPath[w] {
+			fmt.Printf("  %s\n", filepath.Base(r.Path))
+			switch {
+			case r.Error != "":
+				fmt.Printf("    ! not replayed: %s\n", r.Error)
+			case r.Changed:
+				fmt.Printf("    - %s\n    + %s\n", r.Before, r.After)
+			default:
+				fmt.Printf("      %s\n", r.Before)
+			}
+		}
+	}
+	fmt.Printf("\n%d changed, %d unchanged, %d not replayed\n", changed, unchanged, failed)
+	return
+}
+
+// test Run the scenario files in a directory against the config file
+//
+// Each scenario is handled in its own sandbox. An error is returned if any
+// scenario fails so the exit status can be checked.
+func test(args []string) (err error) {
+	var (
+		flags   *flag.FlagSet = flag.NewFlagSet("test", flag.ExitOnError)
+		options scenario.Options
+		format  *string = flags.String("format", "text", "How to report the results. One of text or junit")
+		suites  []*scenario.Suite
+		results []scenario.Result
+		failed  int
+	)
+	flags.StringVar(&options.ConfigFile, "config", "", "Path to config file. Defaults to "+c.DefaultConfigFile)
+	flags.BoolVar(&options.Keep, "keep", false, "Keep the sandbox of each scenario")
+	flags.BoolVar(&options.Verbose, "v", false, "Show the log of each scenario")
+	if err = flags.Parse(args); err != nil {
+		return
+	}
+	if *format != "text" && *format != "junit" {
+		return fmt.Errorf("unknown format '%s'", *format)
+	}
+
+	var dir string = "."
+	if flags.NArg() > 0 {
+		dir = flags.Arg(0)
+	}
+	if suites, err = scenario.Load(dir); err != nil {
+		return
+	}
+	for _, s := range suites {
+		results = append(results, s.Run(options)...)
+	}
+
+	if *format == "junit" {
+		if failed, err = scenario.JUnit(os.Stdout, results); err != nil {
+			return
+		}
+	} else {
+		failed = scenario.Text(os.Stdout, results)
+	}
+	if failed > 0 {
+		err = fmt.Errorf("%d of %d scenarios failed", failed, len(results))
+	}
+	return
+}
+
+// parseAge Parse a duration which may also be given in days, e.g. `30d`
+func parseAge(value string) (time.Duration, error) {
+	if strings.HasSuffix(value, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(value, "d"))
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration '%s'", value)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(value)
+}
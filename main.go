@@ -5,10 +5,15 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"strings"
+	"time"
 
 	n "github.com/0xAX/notificator"
+	"github.com/mproffitt/importmanager/pkg/checksum"
 	c "github.com/mproffitt/importmanager/pkg/config"
 	h "github.com/mproffitt/importmanager/pkg/handler"
+	"github.com/mproffitt/importmanager/pkg/index"
+	"github.com/mproffitt/importmanager/pkg/journal"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -31,6 +36,7 @@ func notification(notification chan string) {
 func main() {
 	var (
 		filename string
+		manager  *c.Manager
 		config   *c.Config
 		err      error
 		sigc     chan os.Signal = make(chan os.Signal, 1)
@@ -38,6 +44,13 @@ func main() {
 		finished chan bool      = make(chan bool, 1)
 		done     chan bool      = make(chan bool, 1)
 	)
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		if err = runCommand(os.Args[1], os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	signal.Notify(sigc, os.Interrupt)
 
 	go func() {
@@ -51,23 +64,42 @@ func main() {
 		}
 	}()
 
-	flag.StringVar(&filename, "config", "", "Path to config file")
+	flag.StringVar(&filename, "config", "", "Path to config file. Defaults to "+c.DefaultConfigFile)
 	flag.Parse()
-	if _, err = os.Stat(filename); err != nil || filename == "" {
-		log.Fatalf("config file must be provided and must exist")
+	filename = c.ConfigFile(filename)
+	if _, err = os.Stat(filename); err != nil {
+		log.Fatalf("config file %s does not exist", filename)
+		return
+	}
+
+	if manager, err = c.NewManager(filename, h.Handle); err != nil {
+		log.Fatalf("Config file is invalid or doesn't exist. %s", err)
+		return
+	}
+	config = manager.Current()
+
+	if err = os.MkdirAll(config.CacheDirectory, 0750); err != nil {
+		log.Fatalf("Unable to create cache directory. %q", err)
+		return
+	}
+	if err = checksum.Setup(config.CacheDirectory, config.HashAlgorithm); err != nil {
+		log.Fatalf("Invalid hash algorithm. %q", err)
 		return
 	}
+	index.Setup(config)
 
-	if config, err = c.New(filename, h.Handle, true); err != nil {
-		log.Fatalf("Config file is invalid or doesn't exist. %q", err)
+	if err = journal.Open(config.StateDirectory, config.Journal.Retention*24*time.Hour); err != nil {
+		log.Fatalf("Unable to open journal. %q", err)
 		return
 	}
+	defer journal.Close()
 
 	var notifications chan string = make(chan string)
 	go notification(notifications)
 
 	log.Debug(fmt.Sprintf("%+v", config))
 	log.Info("Starting watchers")
-	h.Setup(config, stop, finished, notifications)
+	h.Setup(manager, stop, finished, notifications)
 	<-done
+	checksum.Save()
 }
@@ -0,0 +1,324 @@
+package checksum
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/zeebo/blake3"
+	"github.com/zeebo/xxh3"
+)
+
+const (
+	// SHA256 Cryptographic hash. Use where content must be verified
+	SHA256 = "sha256"
+
+	// BLAKE3 Fast cryptographic hash
+	BLAKE3 = "blake3"
+
+	// XXH3 Very fast non-cryptographic hash
+	XXH3 = "xxh3"
+)
+
+// SaveInterval How often the cache is written to the cache directory
+const SaveInterval = 60 * time.Second
+
+// Expiry How long a hash is kept once the file can no longer be found at
+// the path it was last seen at
+//
+// Entries are keyed by inode so a file which was moved keeps its hash until
+// it is looked up under its new path.
+const Expiry = 30 * 24 * time.Hour
+
+// DefaultAlgorithm The algorithm used when none is specified
+var DefaultAlgorithm string = SHA256
+
+var hashes *cache = &cache{
+	entries: make(map[key]record),
+}
+
+// Setup Load the hash cache and set the default algorithm
+//
+// If the cache directory exists, previously calculated hashes are loaded from
+// it and the cache is periodically written back.
+//
+// Arguments:
+//
+// - cacheDirectory string The directory to keep the cache in
+// - algorithm      string The default algorithm. Empty keeps the current default
+func Setup(cacheDirectory, algorithm string) (err error) {
+	if algorithm != "" {
+		if _, err = newHasher(algorithm); err != nil {
+			return
+		}
+		DefaultAlgorithm = strings.ToLower(algorithm)
+	}
+
+	if fi, e := os.Stat(cacheDirectory); e != nil || !fi.IsDir() {
+		return
+	}
+
+	hashes.Lock()
+	hashes.file = filepath.Join(cacheDirectory, "hashes.json")
+	hashes.Unlock()
+	hashes.load()
+	go func() {
+		for {
+			<-time.After(SaveInterval)
+			if err := Save(); err != nil {
+				log.Errorf("Unable to save hash cache - %s", err.Error())
+			}
+		}
+	}()
+	return
+}
+
+// Save Write the cache to the cache directory if it has changed
+//
+// Entries for files which have not been found at their last known path for
+// longer than Expiry are dropped first.
+func Save() (err error) {
+	hashes.prune()
+	hashes.Lock()
+	defer hashes.Unlock()
+	if hashes.file == "" || !hashes.dirty {
+		return
+	}
+
+	var records []record = make([]record, 0, len(hashes.entries))
+	for _, r := range hashes.entries {
+		records = append(records, r)
+	}
+
+	var data []byte
+	if data, err = json.Marshal(records); err != nil {
+		return
+	}
+
+	var tmp string = hashes.file + ".tmp"
+	if err = ioutil.WriteFile(tmp, data, 0640); err != nil {
+		return
+	}
+	if err = os.Rename(tmp, hashes.file); err == nil {
+		hashes.dirty = false
+	}
+	return
+}
+
+// Sum Get the hash of a file
+//
+// Results are cached against the device, inode, size and modification time
+// of the file so unchanged files are only ever read once.
+//
+// Arguments:
+//
+// - path      string The file to hash
+// - algorithm string One of sha256, blake3 or xxh3. Empty uses the default
+//
+// Return:
+//
+// - string The hex encoded hash
+// - error  Any error opening or reading the file
+func Sum(path, algorithm string) (sum string, err error) {
+	if algorithm == "" {
+		algorithm = DefaultAlgorithm
+	}
+	algorithm = strings.ToLower(algorithm)
+
+	var fi os.FileInfo
+	if fi, err = os.Stat(path); err != nil {
+		return
+	}
+
+	var k, ok = identify(fi, algorithm)
+	if ok {
+		hashes.Lock()
+		r, found := hashes.entries[k]
+		if found {
+			// The file may have been moved since it was hashed
+			if r.Path != path {
+				r.Path = path
+				hashes.dirty = true
+			}
+			r.Used = time.Now().Unix()
+			hashes.entries[k] = r
+		}
+		hashes.Unlock()
+		if found {
+			log.Debugf("Using cached %s for %s", algorithm, path)
+			return r.Sum, nil
+		}
+	}
+
+	if sum, err = hashFile(path, algorithm); err != nil || !ok {
+		return
+	}
+
+	hashes.Lock()
+	hashes.entries[k] = record{key: k, Path: path, Sum: sum, Used: time.Now().Unix()}
+	hashes.dirty = true
+	hashes.Unlock()
+	return
+}
+
+// Equal Test if two files have the same content
+//
+// Files of differing size are never equal and are not read.
+func Equal(a, b, algorithm string) bool {
+	fa, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	fb, err := os.Stat(b)
+	if err != nil || fa.Size() != fb.Size() {
+		return false
+	}
+
+	if algorithm == "" {
+		algorithm = DefaultAlgorithm
+	}
+	log.Infof("Comparing %s between %s and %s", algorithm, a, b)
+	var x, y string
+	if x, err = Sum(a, algorithm); err != nil {
+		log.Error(err)
+		return false
+	}
+	if y, err = Sum(b, algorithm); err != nil {
+		log.Error(err)
+		return false
+	}
+	return x == y
+}
+
+func newHasher(algorithm string) (h hasher, err error) {
+	switch strings.ToLower(algorithm) {
+	case SHA256:
+		h = sha256.New()
+	case BLAKE3:
+		h = blake3.New()
+	case XXH3:
+		h = xxh3.New()
+	default:
+		err = fmt.Errorf("unknown hash algorithm '%s'", algorithm)
+	}
+	return
+}
+
+func hashFile(path, algorithm string) (sum string, err error) {
+	var h hasher
+	if h, err = newHasher(algorithm); err != nil {
+		return
+	}
+
+	var f *os.File
+	if f, err = os.Open(path); err != nil {
+		return
+	}
+	defer f.Close()
+
+	if _, err = io.Copy(h, f); err != nil {
+		return
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+func identify(fi os.FileInfo, algorithm string) (k key, ok bool) {
+	var st *syscall.Stat_t
+	if st, ok = fi.Sys().(*syscall.Stat_t); !ok {
+		return
+	}
+	k = key{
+		Device:    uint64(st.Dev),
+		Inode:     st.Ino,
+		Size:      fi.Size(),
+		ModTime:   fi.ModTime().UnixNano(),
+		Algorithm: algorithm,
+	}
+	return
+}
+
+// prune Drop entries for files which have not been seen for longer than
+// Expiry
+//
+// Files are checked without holding the lock so hashing is not blocked.
+func (c *cache) prune() {
+	c.RLock()
+	var records []record = make([]record, 0, len(c.entries))
+	for _, r := range c.entries {
+		records = append(records, r)
+	}
+	c.RUnlock()
+
+	var (
+		now   time.Time = time.Now()
+		stale []key     = make([]key, 0)
+	)
+	for _, r := range records {
+		if r.expired(now) {
+			stale = append(stale, r.key)
+		}
+	}
+	if len(stale) == 0 {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	for _, k := range stale {
+		delete(c.entries, k)
+	}
+	c.dirty = true
+	log.Debugf("Dropped %d cached hashes for files which could not be found", len(stale))
+}
+
+// expired Test if a file is no longer at the path it was last seen at and
+// its hash has not been used for longer than Expiry
+func (r record) expired(now time.Time) bool {
+	if fi, err := os.Stat(r.Path); err == nil {
+		if k, ok := identify(fi, r.Algorithm); ok && k == r.key {
+			return false
+		}
+	}
+	return now.Sub(time.Unix(r.Used, 0)) > Expiry
+}
+
+// load Read the cache from disk, discarding entries for files which have
+// not been seen for longer than Expiry
+func (c *cache) load() {
+	c.Lock()
+	defer c.Unlock()
+
+	var (
+		data    []byte
+		err     error
+		records []record
+	)
+	if data, err = ioutil.ReadFile(c.file); err != nil {
+		return
+	}
+	if err = json.Unmarshal(data, &records); err != nil {
+		log.Errorf("Unable to read hash cache %s - %s", c.file, err.Error())
+		return
+	}
+
+	var now time.Time = time.Now()
+	for _, r := range records {
+		// Entries written before use was recorded start from now
+		if r.Used == 0 {
+			r.Used = now.Unix()
+		}
+		if !r.expired(now) {
+			c.entries[r.key] = r
+		}
+	}
+	c.dirty = len(c.entries) != len(records)
+	log.Infof("Loaded %d cached hashes", len(c.entries))
+}
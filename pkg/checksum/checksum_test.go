@@ -0,0 +1,102 @@
+package checksum
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// cached Get the cache entry for a file
+func cached(t *testing.T, path string) (record, bool) {
+	t.Helper()
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, ok := identify(fi, SHA256)
+	if !ok {
+		t.Skip("inodes are not available")
+	}
+	hashes.RLock()
+	defer hashes.RUnlock()
+	r, found := hashes.entries[k]
+	return r, found
+}
+
+func TestPruneKeepsMovedFiles(t *testing.T) {
+	var (
+		dir   string = t.TempDir()
+		path  string = filepath.Join(dir, "before.iso")
+		moved string = filepath.Join(dir, "after.iso")
+	)
+	if err := ioutil.WriteFile(path, []byte("content"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	sum, err := Sum(path, SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Rename(path, moved); err != nil {
+		t.Fatal(err)
+	}
+
+	hashes.prune()
+	if _, found := cached(t, moved); !found {
+		t.Fatal("expected the hash of a moved file to be kept")
+	}
+
+	if again, err := Sum(moved, SHA256); err != nil || again != sum {
+		t.Fatalf("expected %s for the moved file, got %s (%v)", sum, again, err)
+	}
+	if r, _ := cached(t, moved); r.Path != moved {
+		t.Errorf("expected the cached path to follow the file to %s, got %s", moved, r.Path)
+	}
+}
+
+func TestPruneDropsExpiredEntries(t *testing.T) {
+	var (
+		dir     string = t.TempDir()
+		path    string = filepath.Join(dir, "file.iso")
+		removed string = filepath.Join(dir, "removed.iso")
+	)
+	if err := ioutil.WriteFile(path, []byte("content"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Sum(path, SHA256); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, _ := identify(fi, SHA256)
+
+	var tests = []struct {
+		name string
+		path string
+		used time.Time
+		kept bool
+	}{
+		{name: "found at its path", path: path, used: time.Now().Add(-2 * Expiry), kept: true},
+		{name: "missing but recently used", path: removed, used: time.Now(), kept: true},
+		{name: "missing and unused", path: removed, used: time.Now().Add(-2 * Expiry), kept: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hashes.Lock()
+			hashes.entries[k] = record{key: k, Path: tt.path, Sum: "sum", Used: tt.used.Unix()}
+			hashes.Unlock()
+
+			hashes.prune()
+
+			hashes.RLock()
+			_, found := hashes.entries[k]
+			hashes.RUnlock()
+			if found != tt.kept {
+				t.Errorf("expected kept %t, got %t", tt.kept, found)
+			}
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package checksum
+
+import (
+	"io"
+	"sync"
+)
+
+type hasher interface {
+	io.Writer
+	Sum(b []byte) []byte
+}
+
+// key Identifies a specific version of a file on disk
+type key struct {
+	Device    uint64 `json:"device"`
+	Inode     uint64 `json:"inode"`
+	Size      int64  `json:"size"`
+	ModTime   int64  `json:"mtime"`
+	Algorithm string `json:"algorithm"`
+}
+
+// record A cache entry as stored in the state directory
+//
+// Path is where the file was last hashed or looked up and Used when, as a
+// unix time.
+type record struct {
+	key
+	Path string `json:"path"`
+	Sum  string `json:"sum"`
+	Used int64  `json:"used"`
+}
+
+type cache struct {
+	sync.RWMutex
+	entries map[key]record
+	file    string
+	dirty   bool
+}
@@ -0,0 +1,201 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// BackupDirectory Where copies of known good config files are kept inside
+// the state directory
+const BackupDirectory = "config"
+
+// BackupTimeFormat The time format appended to the name of each backup
+const BackupTimeFormat = "20060102-150405.000000"
+
+// backup Keep a copy of the config files which loaded and validated
+//
+// Each backup is a directory named after the config file and the time it
+// was taken. It holds the config file and every file it includes or merges
+// from `conf.d`, each under its absolute path. Only the newest
+// `configBackups` copies are kept and nothing is written if the files are
+// the same as the newest copy.
+func (c *Config) backup(configFile string) {
+	var backups, err = Backups(c.StateDirectory, configFile)
+	if err != nil {
+		log.Errorf("Unable to list config backups - %s", err.Error())
+		return
+	}
+
+	var files map[string][]byte = make(map[string][]byte)
+	for i, source := range c.sources {
+		if i == 0 {
+			// The config file as it was loaded
+			files[source] = c.source
+			continue
+		}
+		if files[source], err = ioutil.ReadFile(source); err != nil {
+			log.Errorf("Unable to back up config file %s - %s", source, err.Error())
+			return
+		}
+	}
+	if len(backups) > 0 {
+		if last, err := backupFiles(backups[0]); err == nil && reflect.DeepEqual(last, files) {
+			return
+		}
+	}
+
+	var name string = filepath.Join(c.StateDirectory, BackupDirectory, filepath.Base(configFile)+"."+time.Now().Format(BackupTimeFormat))
+	for source, data := range files {
+		var path string = filepath.Join(name, source)
+		if err = os.MkdirAll(filepath.Dir(path), 0750); err == nil {
+			err = ioutil.WriteFile(path, data, 0640)
+		}
+		if err != nil {
+			log.Errorf("Unable to back up config file %s - %s", source, err.Error())
+			os.RemoveAll(name)
+			return
+		}
+	}
+	log.Debugf("Backed up %d config files of %s to %s", len(files), configFile, name)
+
+	for i, old := range backups {
+		if i+1 >= c.ConfigBackups {
+			os.RemoveAll(old)
+		}
+	}
+}
+
+// backupFiles Read the files held in a backup, keyed by their original path
+func backupFiles(backup string) (files map[string][]byte, err error) {
+	files = make(map[string][]byte)
+	err = filepath.Walk(backup, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return err
+		}
+		var rel string
+		if rel, err = filepath.Rel(backup, path); err != nil {
+			return err
+		}
+		if files[string(filepath.Separator)+rel], err = ioutil.ReadFile(path); err != nil {
+			return err
+		}
+		return nil
+	})
+	return
+}
+
+// Backups List the known good copies of a config file, newest first
+//
+// Arguments:
+//
+// - stateDirectory string The state directory the backups were written to
+// - configFile     string The config file the backups were taken of
+//
+// Return:
+//
+// - []string The path to each backup
+// - error    Any error reading the backup directory
+func Backups(stateDirectory, configFile string) (backups []string, err error) {
+	backups = make([]string, 0)
+	expandPath(&stateDirectory)
+
+	var (
+		dir     string = filepath.Join(stateDirectory, BackupDirectory)
+		prefix  string = filepath.Base(configFile) + "."
+		entries []os.FileInfo
+	)
+	if entries, err = ioutil.ReadDir(dir); err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), prefix) {
+			backups = append(backups, filepath.Join(dir, e.Name()))
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(backups)))
+	return
+}
+
+// Restore Replace a config file and the files it includes with a backup
+//
+// The backup is validated before anything is written. Each file is
+// replaced in a single rename so a running instance never reads a partly
+// written file. The config file itself is written last so it is reloaded
+// once the files it includes are in place. Files added to `conf.d` since
+// the backup was taken are left as they are.
+//
+// Arguments:
+//
+// - backup     string The backup to restore
+// - configFile string The config file to replace
+//
+// Return:
+//
+// - error Any error validating the backup or writing the config files
+func Restore(backup, configFile string) (err error) {
+	var fi os.FileInfo
+	if fi, err = os.Stat(backup); err != nil {
+		return
+	}
+	if !fi.IsDir() {
+		var c *Config
+		if c, err = parse(backup, nil); err != nil {
+			return fmt.Errorf("backup %s is not valid - %s", backup, err.Error())
+		}
+		return replaceFile(configFile, c.source)
+	}
+
+	if abs, err := filepath.Abs(configFile); err == nil {
+		configFile = abs
+	}
+	var files map[string][]byte
+	if files, err = backupFiles(backup); err != nil {
+		return
+	}
+	if _, ok := files[configFile]; !ok {
+		return fmt.Errorf("backup %s does not hold %s", backup, configFile)
+	}
+	if _, err = parse(filepath.Join(backup, configFile), nil); err != nil {
+		return fmt.Errorf("backup %s is not valid - %s", backup, err.Error())
+	}
+
+	for path, data := range files {
+		if path == configFile {
+			continue
+		}
+		if err = os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+			return
+		}
+		if err = replaceFile(path, data); err != nil {
+			return
+		}
+	}
+	return replaceFile(configFile, files[configFile])
+}
+
+// replaceFile Write a file in a single rename, keeping its permissions
+func replaceFile(filename string, data []byte) (err error) {
+	var mode os.FileMode = 0640
+	if fi, err := os.Stat(filename); err == nil {
+		mode = fi.Mode().Perm()
+	}
+	var tmp string = filename + ".tmp"
+	if err = ioutil.WriteFile(tmp, data, mode); err != nil {
+		return
+	}
+	if err = os.Rename(tmp, filename); err != nil {
+		os.Remove(tmp)
+	}
+	return
+}
@@ -0,0 +1,84 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// write Create a file for a test, along with its directory
+func write(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(content), 0640); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// read Get the content of a file for a test
+func read(t *testing.T, path string) string {
+	t.Helper()
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestBackupRestoresIncludedFiles(t *testing.T) {
+	var (
+		dir        string = t.TempDir()
+		configFile string = filepath.Join(dir, "config.yaml")
+		included   string = filepath.Join(dir, "paths.yaml")
+		fragment   string = filepath.Join(dir, FragmentDirectory, "extra.yaml")
+		state      string = filepath.Join(dir, "state")
+	)
+	write(t, configFile, "stateDirectory: "+state+"\ninclude:\n  - paths.yaml\n")
+	write(t, included, "paths:\n  - path: /watched/one\n    processors: []\n")
+	write(t, fragment, "workers: 2\n")
+
+	config, err := parse(configFile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.backup(configFile)
+	// An unchanged config is not backed up again
+	config.backup(configFile)
+
+	backups, err := Backups(state, configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 1 {
+		t.Fatalf("expected 1 backup, found %d", len(backups))
+	}
+
+	write(t, included, "paths:\n  - path: /watched/two\n    processors: []\n")
+	write(t, fragment, "workers: 3\n")
+	if config, err = parse(configFile, nil); err != nil {
+		t.Fatal(err)
+	}
+	config.backup(configFile)
+	if backups, err = Backups(state, configFile); err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 2 {
+		t.Fatalf("expected a backup for each change, found %d", len(backups))
+	}
+
+	if err = Restore(backups[1], configFile); err != nil {
+		t.Fatal(err)
+	}
+	if config, err = parse(configFile, nil); err != nil {
+		t.Fatal(err)
+	}
+	if config.Paths[0].Path != "/watched/one" || config.Workers != 2 {
+		t.Errorf("expected the first config to be restored, got %s with %d workers", config.Paths[0].Path, config.Workers)
+	}
+	if got := read(t, fragment); got != "workers: 2\n" {
+		t.Errorf("expected the fragment to be restored, got %q", got)
+	}
+}
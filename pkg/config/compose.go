@@ -0,0 +1,307 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// FragmentDirectory The directory next to the config file whose `*.yaml`
+// files are merged into it
+const FragmentDirectory = "conf.d"
+
+// variable Matches `${NAME}` in a path
+var variable *regexp.Regexp = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// xdgDefaults The values of the XDG base directories when they are not set
+var xdgDefaults = map[string]string{
+	"XDG_CONFIG_HOME": "~/.config",
+	"XDG_DATA_HOME":   "~/.local/share",
+	"XDG_STATE_HOME":  "~/.local/state",
+	"XDG_CACHE_HOME":  "~/.cache",
+	"XDG_DATA_DIRS":   "/usr/local/share:/usr/share",
+}
+
+// compose Merge a config file with the files it includes and its fragments
+//
+// Files are merged in order. First the config file, then each file it
+// includes, then each `*.yaml` file in the `conf.d` directory next to it in
+// name order. Included files and fragments may include further files.
+// When merging, lists are appended, maps are merged and any other value
+// replaces the one before it. Each file is migrated to CurrentVersion before
+// it is merged.
+//
+// Arguments:
+//
+// - filename string The config file
+// - data     []byte The contents of the config file
+//
+// Return:
+//
+// - []byte   The merged config
+// - []string Every file which was read
+// - error    Any error reading or parsing a file
+func compose(filename string, data []byte) (merged []byte, sources []string, err error) {
+	var (
+		tree interface{}
+		seen map[string]bool = make(map[string]bool)
+	)
+	sources = make([]string, 0)
+	if data, err = upgrade(filename, data); err != nil {
+		return
+	}
+	if tree, err = include(filename, data, nil, seen, &sources); err != nil {
+		return
+	}
+
+	var fragments []string
+	if fragments, err = fragmentFiles(filename); err != nil {
+		return
+	}
+	for _, fragment := range fragments {
+		if tree, err = include(fragment, nil, tree, seen, &sources); err != nil {
+			return
+		}
+	}
+
+	if len(sources) == 1 {
+		return data, sources, nil
+	}
+	merged, err = yaml.Marshal(tree)
+	return
+}
+
+// include Merge a file and everything it includes into tree
+func include(filename string, data []byte, tree interface{}, seen map[string]bool, sources *[]string) (interface{}, error) {
+	if abs, err := filepath.Abs(filename); err == nil {
+		filename = abs
+	}
+	if seen[filename] {
+		return nil, fmt.Errorf("%s is included more than once", filename)
+	}
+	seen[filename] = true
+	*sources = append(*sources, filename)
+
+	var err error
+	if data == nil {
+		if data, err = ioutil.ReadFile(filename); err != nil {
+			return nil, err
+		}
+		if data, err = upgrade(filename, data); err != nil {
+			return nil, err
+		}
+	}
+	var (
+		next     interface{}
+		includes struct {
+			Include []string `yaml:"include"`
+		}
+	)
+	if err = yaml.Unmarshal(data, &next); err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err.Error())
+	}
+	if err = yaml.Unmarshal(data, &includes); err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err.Error())
+	}
+	if n, ok := next.(map[interface{}]interface{}); ok {
+		delete(n, "include")
+	}
+	tree = merge(tree, next)
+
+	for _, pattern := range includes.Include {
+		expandPath(&pattern)
+		if name, ok := unresolved(pattern); ok {
+			return nil, fmt.Errorf("%s: include %s uses undefined variable %s", filename, pattern, name)
+		}
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(filename), pattern)
+		}
+		var matches []string
+		if matches, err = filepath.Glob(pattern); err != nil {
+			return nil, fmt.Errorf("%s: %s", filename, err.Error())
+		}
+		if len(matches) == 0 && !strings.ContainsAny(pattern, "*?[") {
+			return nil, fmt.Errorf("%s: included file %s does not exist", filename, pattern)
+		}
+		sort.Strings(matches)
+		for _, match := range matches {
+			if tree, err = include(match, nil, tree, seen, sources); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return tree, nil
+}
+
+// fragmentFiles List the files in the `conf.d` directory next to a config file
+func fragmentFiles(filename string) (fragments []string, err error) {
+	fragments = make([]string, 0)
+	var entries []os.FileInfo
+	if entries, err = ioutil.ReadDir(filepath.Join(filepath.Dir(filename), FragmentDirectory)); err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+	for _, e := range entries {
+		var ext string = filepath.Ext(e.Name())
+		if !e.IsDir() && (ext == ".yaml" || ext == ".yml") {
+			fragments = append(fragments, filepath.Join(filepath.Dir(filename), FragmentDirectory, e.Name()))
+		}
+	}
+	return
+}
+
+// merge Merge next into base. Lists are appended, maps are merged and any
+// other value in next replaces the one in base
+func merge(base, next interface{}) interface{} {
+	switch n := next.(type) {
+	case map[interface{}]interface{}:
+		var b, ok = base.(map[interface{}]interface{})
+		if !ok {
+			return n
+		}
+		for k, v := range n {
+			b[k] = merge(b[k], v)
+		}
+		return b
+	case []interface{}:
+		if b, ok := base.([]interface{}); ok {
+			return append(b, n...)
+		}
+		return n
+	case nil:
+		return base
+	}
+	return next
+}
+
+// expandVariables Replace `${NAME}` in a path with the value of the
+// environment variable NAME
+//
+// The XDG base directories fall back to their defaults when not set and
+// user directories such as `XDG_DOWNLOAD_DIR` are read from `user-dirs.dirs`.
+// Variables which are not set are left in place and reported when the
+// config is validated.
+func expandVariables(path string) string {
+	if !strings.Contains(path, "${") {
+		return path
+	}
+	return variable.ReplaceAllStringFunc(path, func(match string) string {
+		var name string = variable.FindStringSubmatch(match)[1]
+		if value, ok := lookupVariable(name); ok {
+			return value
+		}
+		return match
+	})
+}
+
+func lookupVariable(name string) (string, bool) {
+	if value, ok := os.LookupEnv(name); ok {
+		return value, true
+	}
+	if !strings.HasPrefix(name, "XDG_") {
+		return "", false
+	}
+	if value, ok := xdgDefaults[name]; ok {
+		expandHome(&value)
+		return value, true
+	}
+	return userDirectory(name)
+}
+
+// userDirectory Read a user directory such as `XDG_PICTURES_DIR` from
+// `$XDG_CONFIG_HOME/user-dirs.dirs`
+func userDirectory(name string) (string, bool) {
+	var config, _ = lookupVariable("XDG_CONFIG_HOME")
+	f, err := os.Open(filepath.Join(config, "user-dirs.dirs"))
+	if err != nil {
+		return "", false
+	}
+	defer f.Close()
+
+	var scanner *bufio.Scanner = bufio.NewScanner(f)
+	for scanner.Scan() {
+		var line string = strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, name+"=") {
+			continue
+		}
+		var value string = strings.Trim(strings.TrimPrefix(line, name+"="), `"`)
+		if home, err := os.UserHomeDir(); err == nil {
+			value = strings.Replace(value, "$HOME", home, 1)
+		}
+		return value, true
+	}
+	return "", false
+}
+
+// unresolved Find a variable left in a path after expansion
+func unresolved(path string) (string, bool) {
+	if m := variable.FindStringSubmatch(path); m != nil {
+		return m[1], true
+	}
+	return "", false
+}
+
+// resolvePreset Apply the preset named by `use` to a processor
+//
+// Fields set on the processor override those of the preset. Properties are
+// merged with those set on the processor taking precedence.
+func (c *Config) resolvePreset(q *Processor) error {
+	if q.Use == "" {
+		return nil
+	}
+	var preset, ok = c.Presets[q.Use]
+	if !ok {
+		return fmt.Errorf("unknown preset '%s'", q.Use)
+	}
+	var p Processor = preset.Clone()
+	if q.Type != "" {
+		p.Type = q.Type
+	}
+	if q.Path != "" {
+		p.Path = q.Path
+	}
+	if q.Handler != "" {
+		p.Handler = q.Handler
+	}
+	for k, v := range q.Properties {
+		p.Properties[k] = v
+	}
+	if q.Priority != 0 {
+		p.Priority = q.Priority
+	}
+	if q.Retry.Attempts != 0 {
+		p.Retry.Attempts = q.Retry.Attempts
+	}
+	if q.Retry.Backoff != 0 {
+		p.Retry.Backoff = q.Retry.Backoff
+	}
+	if q.Retry.MaxBackoff != 0 {
+		p.Retry.MaxBackoff = q.Retry.MaxBackoff
+	}
+	p.Use = q.Use
+	*q = p
+	return nil
+}
+
+// Clone Copy a processor so its properties can be changed independently
+//
+// Return:
+//
+// - Processor A copy of the processor with its own properties
+func (p Processor) Clone() Processor {
+	var properties map[string]string = make(map[string]string)
+	for k, v := range p.Properties {
+		properties[k] = v
+	}
+	p.Properties = properties
+	return p
+}
@@ -1,7 +1,6 @@
 package config
 
 import (
-	"context"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -41,40 +40,157 @@ func (p *Processor) String() string {
 // MaxRetries Maximum number of retries for operations
 const MaxRetries = 100
 
-// DefaultBufferSize When not set, the jobs buffer will be this size
-const DefaultBufferSize = 50
+// DefaultWorkers The number of jobs run at the same time across all paths
+// when `workers` is not set
+const DefaultWorkers = 4
 
-// New Create a new Config object
+// DefaultReadinessChecks Readiness checks applied when a path does not set any
+var DefaultReadinessChecks = []string{"partial"}
+
+// DefaultSamples The number of samples taken by the `stable` readiness check
+const DefaultSamples = 3
+
+// DefaultSampleInterval Milliseconds between samples for the `stable` readiness check
+const DefaultSampleInterval = 500
+
+// How directories which appear in a watched path are handled
+const (
+	// DirectoryIgnore Directories are not handled (default)
+	DirectoryIgnore = "ignore"
+
+	// DirectoryRecurse Each file inside the directory is handled
+	DirectoryRecurse = "recurse"
+
+	// DirectoryWhole The directory is handled as `inode/directory`
+	DirectoryWhole = "whole"
+
+	// DirectoryDominant The directory is handled as the most common type of its contents
+	DirectoryDominant = "dominant"
+)
+
+// How changes to a watched path are detected
+const (
+	// WatcherAuto Poll network and FUSE filesystems, otherwise use inotify (default)
+	WatcherAuto = "auto"
+
+	// WatcherInotify Changes are reported by the kernel
+	WatcherInotify = "inotify"
+
+	// WatcherPoll The path is scanned every `pollInterval` seconds
+	WatcherPoll = "poll"
+)
+
+// What happens to files found in a watched path
+const (
+	// ModeHandle Files are handled by their processor (default)
+	ModeHandle = "handle"
+
+	// ModeObserve The action each processor would take is recorded in the
+	// journal but files are not touched
+	ModeObserve = "observe"
+)
+
+// DefaultPollInterval Seconds between scans of a polled path
+const DefaultPollInterval = 10
+
+// DefaultIncludeHidden Whether files starting with `.` are handled when a
+// path does not set `includeHidden`
+const DefaultIncludeHidden = true
+
+// Scheduling policies for the worker pool
+const (
+	// PolicyFIFO Files are handled in the order they were last changed (default)
+	PolicyFIFO = "fifo"
+
+	// PolicySmallestFirst Smaller files are handled before larger ones
+	PolicySmallestFirst = "smallest-first"
+)
+
+// DefaultAging Seconds a queued file waits before its priority is raised by one
+const DefaultAging = 60
+
+// DefaultRetention Days finished jobs are kept in the journal
+const DefaultRetention = 30
+
+// DefaultAttempts The number of times a processor is run before the file is
+// quarantined
+const DefaultAttempts = 3
+
+// DefaultBackoff Seconds to wait before the first retry
+const DefaultBackoff = 5
+
+// DefaultMaxBackoff The longest wait in seconds between retries
+const DefaultMaxBackoff = 300
+
+// DefaultMaxHops The number of processors the same content may pass through
+// within the loop detection window
+const DefaultMaxHops = 5
+
+// DefaultLoopWindow Seconds a file's route is remembered for loop detection
+const DefaultLoopWindow = 600
+
+// DefaultStateDirectory Where persistent state is kept when not set
+const DefaultStateDirectory = "${XDG_STATE_HOME}/importmanager"
+
+// DefaultRescanInterval Time in seconds between content index rescans
+const DefaultRescanInterval = 3600
+
+// DefaultConfigBackups The number of known good config files kept when not set
+const DefaultConfigBackups = 10
+
+// New Load and validate a config file
 //
 // Arguments:
 //
 // - configFile  string  The full path to the config file to load
 // - pathHandler handler A custom function of type handler to call during dry-run
-// - autoReload  bool    If true, sets up watches on the config file and  automatically reloads it when it changes
 //
 // Return:
 //
 // - *Config A pointer to the loaded configuration
-// - error   The last error which occured during loading
-func New(configFile string, pathHandler handler, autoReload bool) (c *Config, err error) {
-	c = &Config{
-		pathHandler: pathHandler,
-	}
-
+// - error   The last error which occured during loading or validation
+func New(configFile string, pathHandler handler) (c *Config, err error) {
 	log.SetFormatter(&log.TextFormatter{
 		DisableColors: true,
 		FullTimestamp: true,
 	})
-	if autoReload {
-		go c.watch(context.Background(), configFile)
+	if c, err = parse(configFile, pathHandler); err != nil {
+		return
 	}
-	err = c.load(configFile)
+	c.apply()
 	return
 }
 
+// parse Read a config file into a new Config and validate it
+//
+// Nothing outside of the returned Config is changed so an invalid file can
+// be rejected without affecting the running configuration.
+func parse(configFile string, pathHandler handler) (c *Config, err error) {
+	c = &Config{
+		pathHandler: pathHandler,
+	}
+	if err = c.load(configFile); err != nil {
+		return nil, err
+	}
+	if err = c.validate(); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// expandPath Expand `${NAME}` variables and a leading `~` in a path
+func expandPath(path *string) {
+	*path = expandVariables(*path)
+	expandHome(path)
+}
+
 func expandHome(path *string) {
 	var p string = (*path)
-	if p[0] == '~' && p[1] != '/' {
+	if p == "" || p[0] != '~' {
+		return
+	}
+
+	if len(p) == 1 || p[1] != '/' {
 		p = "~/" + p[1:]
 	}
 
@@ -88,49 +204,142 @@ func expandHome(path *string) {
 }
 
 func (c *Config) load(filename string) (err error) {
-	c.Lock()
-	defer c.Unlock()
-	pwd, _ := os.Getwd()
-	log.Infof("Loading config file %s/%s", pwd, filename)
+	if abs, err := filepath.Abs(filename); err == nil {
+		filename = abs
+	}
+	log.Infof("Loading config file %s", filename)
 
 	var f []byte
 	if f, err = ioutil.ReadFile(filename); err != nil {
 		return
 	}
+	c.source = f
 
-	if err = yaml.Unmarshal(f, c); err != nil {
+	if f, c.sources, err = compose(filename, f); err != nil {
+		return
+	}
+	// Unknown keys are rejected so mistakes are not silently ignored
+	if err = yaml.UnmarshalStrict(f, c); err != nil {
 		return
 	}
 
 	for i := range c.MimeDirectories {
-		expandHome(&c.MimeDirectories[i])
+		expandPath(&c.MimeDirectories[i])
 	}
-	m.Load(c.MimeDirectories)
 
-	if c.BufferSize == 0 {
-		c.BufferSize = DefaultBufferSize
+	if c.Workers == 0 {
+		c.Workers = DefaultWorkers
 	}
 
-	expandHome(&c.PluginPath)
+	if c.Mode == "" {
+		c.Mode = ModeHandle
+	}
+
+	if c.Scheduling.Policy == "" {
+		c.Scheduling.Policy = PolicyFIFO
+	}
+	if c.Scheduling.Aging == 0 {
+		c.Scheduling.Aging = DefaultAging
+	}
+
+	c.locate(filename)
+
+	if c.LoopDetection.MaxHops == 0 {
+		c.LoopDetection.MaxHops = DefaultMaxHops
+	}
+	if c.LoopDetection.Window == 0 {
+		c.LoopDetection.Window = DefaultLoopWindow
+	}
+
+	if c.ConfigBackups == 0 {
+		c.ConfigBackups = DefaultConfigBackups
+	}
+
+	if c.Journal.Retention == 0 {
+		c.Journal.Retention = DefaultRetention
+	}
+
+	if c.ContentIndex.RescanInterval == 0 {
+		c.ContentIndex.RescanInterval = DefaultRescanInterval
+	}
+	for i := range c.ContentIndex.Roots {
+		expandPath(&c.ContentIndex.Roots[i])
+	}
+
+	// Presets are resolved and global processors added to each path
+	// before the defaults are applied to the processors
+	for name, q := range c.Presets {
+		if q.Use != "" {
+			return fmt.Errorf("preset '%s' may not use another preset", name)
+		}
+	}
+	for i := range c.Processors {
+		if err = c.resolvePreset(&c.Processors[i]); err != nil {
+			return fmt.Errorf("global processor %d: %s", i+1, err.Error())
+		}
+	}
+	for i := range c.Paths {
+		for j := range c.Paths[i].Processors {
+			if err = c.resolvePreset(&c.Paths[i].Processors[j]); err != nil {
+				return fmt.Errorf("processor %d of path %s: %s", j+1, c.Paths[i].Path, err.Error())
+			}
+		}
+		for _, q := range c.Processors {
+			c.Paths[i].Processors = append(c.Paths[i].Processors, q.Clone())
+		}
+	}
 
-	c.setupLogging()
 	for i, p := range c.Paths {
-		expandHome(&c.Paths[i].Path)
+		expandPath(&c.Paths[i].Path)
+		if p.Directories == "" {
+			c.Paths[i].Directories = DirectoryIgnore
+		}
+		if p.Watcher == "" {
+			c.Paths[i].Watcher = WatcherAuto
+		}
+		if p.PollInterval == 0 {
+			c.Paths[i].PollInterval = DefaultPollInterval
+		}
+		if p.IncludeHidden == nil {
+			var include bool = DefaultIncludeHidden
+			c.Paths[i].IncludeHidden = &include
+		}
+		if p.Mode == "" {
+			c.Paths[i].Mode = c.Mode
+		}
+		if len(p.Readiness.Checks) == 0 {
+			c.Paths[i].Readiness.Checks = DefaultReadinessChecks
+		}
+		if p.Readiness.Samples == 0 {
+			c.Paths[i].Readiness.Samples = DefaultSamples
+		}
+		if p.Readiness.SampleInterval == 0 {
+			c.Paths[i].Readiness.SampleInterval = DefaultSampleInterval
+		}
 		for j, q := range p.Processors {
-			if q.Type[0] == '!' {
+			if strings.HasPrefix(q.Type, "!") {
 				q.Type = q.Type[1:]
 				q.Negated = true
 			}
-			expandHome(&c.Paths[i].Processors[j].Path)
-			for k, v := range q.Properties {
-				expandHome(&v)
-				c.Paths[i].Processors[j].Properties[k] = v
+			expandPath(&c.Paths[i].Processors[j].Path)
+			var retry *Retry = &c.Paths[i].Processors[j].Retry
+			if retry.Attempts == 0 {
+				retry.Attempts = DefaultAttempts
+			}
+			if retry.Backoff == 0 {
+				retry.Backoff = DefaultBackoff
+			}
+			if retry.MaxBackoff == 0 {
+				retry.MaxBackoff = DefaultMaxBackoff
+			}
+			if !DefaultHandlers.IsBuiltIn(q.Handler) {
+				c.Paths[i].Processors[j].Handler, _ = c.findPlugin(q.Handler)
+			}
+			if err := c.applyDefaults(&c.Paths[i].Processors[j]); err != nil {
+				return err
 			}
-			if c.PluginPath != "" && !DefaultHandlers.IsBuiltIn(q.Handler) {
-				var handler string = filepath.Join(c.PluginPath, q.Handler)
-				if _, err = os.Stat(handler); !os.IsNotExist(err) {
-					c.Paths[i].Processors[j].Handler = handler
-				}
+			if err := c.expandProperties(&c.Paths[i].Processors[j]); err != nil {
+				return err
 			}
 		}
 	}
@@ -141,6 +350,70 @@ func (c *Config) load(filename string) (err error) {
 	return
 }
 
+// apply Set up the logging and mime types for a validated configuration
+func (c *Config) apply() {
+	c.setupLogging()
+	m.Load(c.MimeDirectories)
+}
+
+// IndexRoots Get the roots covered by the content index
+//
+// When no roots are configured, the static part of each processor
+// destination (everything before the first templated component) is used.
+func (c *Config) IndexRoots() (roots []string) {
+	roots = make([]string, 0)
+	if len(c.ContentIndex.Roots) > 0 {
+		return append(roots, c.ContentIndex.Roots...)
+	}
+
+	var candidates []string = make([]string, 0)
+	for _, p := range c.Paths {
+		for _, q := range p.Processors {
+			if root := StaticRoot(q.Path); root != "" && !contains(root, candidates) {
+				candidates = append(candidates, root)
+			}
+		}
+	}
+
+	// Nested roots are covered by their parent
+	for _, root := range candidates {
+		var nested bool = false
+		for _, other := range candidates {
+			if other != root && strings.HasPrefix(root, other+string(filepath.Separator)) {
+				nested = true
+				break
+			}
+		}
+		if !nested {
+			roots = append(roots, root)
+		}
+	}
+	return
+}
+
+// StaticRoot Get the part of a destination path before the first templated component
+func StaticRoot(path string) string {
+	if path == "" {
+		return ""
+	}
+	if i := strings.Index(path, "{{"); i >= 0 {
+		path = path[:i]
+		if j := strings.LastIndex(path, "/"); j >= 0 {
+			path = path[:j]
+		}
+	}
+	return filepath.Clean(path)
+}
+
+func contains(what string, where []string) bool {
+	for _, p := range where {
+		if what == p {
+			return true
+		}
+	}
+	return false
+}
+
 func (c *Config) setupLogging() {
 	switch c.LogLevel {
 	case "trace":
@@ -112,7 +112,7 @@ func executeDryRun(index int, path Path, cnf *Config, done chan bool) {
 		if dt := m.Catagories.FindBestMatchFor(testPath); dt != nil {
 			dt.DryRun = true
 			// trigger this path so it adds the entry for testing against
-			cnf.pathHandler(testPath, *dt, path.Processors, false)
+			cnf.pathHandler(testPath, *dt, path, false)
 			runTests(notCurrent(path, cnf.Paths), cnf.pathHandler)
 		}
 	}
@@ -232,7 +232,7 @@ func runTests(paths []Path, pathHandler handler) {
 				if dt != nil {
 					dt.DryRun = true
 					log.Infof("testing mime type %s on path %s", processor.Type, test.Path)
-					pathHandler(item, *dt, test.Processors, false)
+					pathHandler(item, *dt, test, false)
 					dryrun.deletepath(item)
 				}
 			}
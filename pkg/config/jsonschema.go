@@ -0,0 +1,225 @@
+package config
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// JSONSchemaDialect The version of JSON Schema written by JSONSchema
+const JSONSchemaDialect = "http://json-schema.org/draft-07/schema#"
+
+// enums The values accepted by fields which only take a fixed set, by
+// `<type>.<key>`
+var enums = map[string][]string{
+	"Config.logLevel":      {"trace", "debug", "info", "warn", "error"},
+	"Config.hashAlgorithm": {"sha256", "blake3", "xxh3"},
+	"Config.mode":          {ModeHandle, ModeObserve},
+	"Path.mode":            {ModeHandle, ModeObserve},
+	"Path.directories":     {DirectoryIgnore, DirectoryRecurse, DirectoryWhole, DirectoryDominant},
+	"Path.watcher":         {WatcherAuto, WatcherInotify, WatcherPoll},
+	"Readiness.checks":     {"partial", "stable", "writers", "closewrite"},
+	"Scheduling.policy":    {PolicyFIFO, PolicySmallestFirst},
+}
+
+// jsonSchema A JSON Schema document or subschema
+type jsonSchema map[string]interface{}
+
+// JSONSchema Describe the config file format as a JSON Schema
+//
+// The properties of each processor are described for the built-in handlers
+// and for each plugin in the plugin directories which declares them.
+//
+// Arguments:
+//
+// - pluginDirectories []string The directories to search for plugins
+//
+// Return:
+//
+// - []byte The schema as indented JSON
+// - error  Any error reading the schema of a plugin
+func JSONSchema(pluginDirectories []string) (schema []byte, err error) {
+	var (
+		definitions jsonSchema = make(jsonSchema)
+		root        jsonSchema = structSchema(reflect.TypeOf(Config{}), definitions)
+		handlers    map[string]PropertySchema
+	)
+	if handlers, err = pluginSchemas(pluginDirectories); err != nil {
+		return
+	}
+	for name, properties := range builtinProperties {
+		handlers[name] = properties
+	}
+
+	var names []string = make([]string, 0, len(handlers))
+	for name := range handlers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// Properties are only checked once the handler is known
+	var conditions []jsonSchema = make([]jsonSchema, 0, len(names))
+	for _, name := range names {
+		conditions = append(conditions, jsonSchema{
+			"if": jsonSchema{
+				"properties": jsonSchema{"handler": jsonSchema{"const": name}},
+				"required":   []string{"handler"},
+			},
+			"then": jsonSchema{
+				"properties": jsonSchema{"properties": handlers[name].jsonSchema()},
+			},
+		})
+	}
+	var processor jsonSchema = definitions["Processor"].(jsonSchema)
+	processor["allOf"] = conditions
+	processor["properties"].(jsonSchema)["properties"] = jsonSchema{
+		"type":                 "object",
+		"additionalProperties": jsonSchema{"type": []string{"string", "boolean", "integer"}},
+	}
+	processor["properties"].(jsonSchema)["handler"].(jsonSchema)["examples"] = names
+
+	root["$schema"] = JSONSchemaDialect
+	root["title"] = "importmanager configuration"
+	root["definitions"] = definitions
+	return json.MarshalIndent(root, "", "  ")
+}
+
+// pluginSchemas Read the properties declared by each plugin in a set of
+// directories
+//
+// A plugin found in more than one directory is described by the first.
+//
+// Return:
+//
+// - map[string]PropertySchema The properties by the name of the plugin
+// - error                     Any error reading a schema
+func pluginSchemas(directories []string) (schemas map[string]PropertySchema, err error) {
+	schemas = make(map[string]PropertySchema)
+	for _, dir := range directories {
+		var entries []os.FileInfo
+		if entries, err = ioutil.ReadDir(dir); err != nil {
+			if os.IsNotExist(err) {
+				err = nil
+				continue
+			}
+			return
+		}
+		for _, e := range entries {
+			var name string = e.Name()
+			if _, ok := schemas[name]; ok || e.IsDir() || strings.HasSuffix(name, SchemaSuffix) {
+				continue
+			}
+			var schema PropertySchema
+			if schema, err = readPluginSchema(filepath.Join(dir, strings.TrimSuffix(name, filepath.Ext(name))+SchemaSuffix)); err != nil {
+				return
+			}
+			if schema != nil {
+				schemas[name] = schema
+			}
+		}
+	}
+	return
+}
+
+// jsonSchema Describe a set of properties as a JSON Schema object
+//
+// Values may be given as YAML booleans and numbers as well as strings.
+func (s PropertySchema) jsonSchema() jsonSchema {
+	var properties jsonSchema = make(jsonSchema)
+	for name, property := range s {
+		var schema jsonSchema = jsonSchema{"type": "string"}
+		switch property.Type {
+		case PropertyBool:
+			schema["type"] = []string{"boolean", "string"}
+		case PropertyInteger:
+			schema["type"] = []string{"integer", "string"}
+			schema["pattern"] = "^-?[0-9]+$"
+		case PropertyOctal:
+			schema["type"] = []string{"integer", "string"}
+			schema["pattern"] = "^[0-7]+$"
+		}
+		if property.Description != "" {
+			schema["description"] = property.Description
+		}
+		if property.Default != "" {
+			schema["default"] = property.Default
+		}
+		if len(property.Enum) > 0 {
+			schema["enum"] = property.Enum
+		}
+		properties[name] = schema
+	}
+	return jsonSchema{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+}
+
+// structSchema Describe a struct by its YAML keys
+//
+// Nested structs are added to definitions and referenced by name.
+func structSchema(t reflect.Type, definitions jsonSchema) jsonSchema {
+	var properties jsonSchema = make(jsonSchema)
+	for i := 0; i < t.NumField(); i++ {
+		var field reflect.StructField = t.Field(i)
+		if field.PkgPath != "" || field.Anonymous {
+			continue
+		}
+		var key string = strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if key == "-" {
+			continue
+		}
+		if key == "" {
+			key = strings.ToLower(field.Name)
+		}
+
+		var schema jsonSchema = typeSchema(field.Type, definitions)
+		if values, ok := enums[t.Name()+"."+key]; ok {
+			if schema["type"] == "array" {
+				schema["items"] = jsonSchema{"type": "string", "enum": values}
+			} else {
+				schema["enum"] = values
+			}
+		}
+		properties[key] = schema
+	}
+	return jsonSchema{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+}
+
+// typeSchema Describe a Go type as it is read from YAML
+func typeSchema(t reflect.Type, definitions jsonSchema) jsonSchema {
+	switch t.Kind() {
+	case reflect.Struct:
+		if _, ok := definitions[t.Name()]; !ok {
+			// Reserve the name first so recursive types terminate
+			definitions[t.Name()] = jsonSchema{}
+			definitions[t.Name()] = structSchema(t, definitions)
+		}
+		return jsonSchema{"$ref": "#/definitions/" + t.Name()}
+	case reflect.Ptr:
+		return typeSchema(t.Elem(), definitions)
+	case reflect.Slice, reflect.Array:
+		return jsonSchema{"type": "array", "items": typeSchema(t.Elem(), definitions)}
+	case reflect.Map:
+		return jsonSchema{"type": "object", "additionalProperties": typeSchema(t.Elem(), definitions)}
+	case reflect.Bool:
+		return jsonSchema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return jsonSchema{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return jsonSchema{"type": "number"}
+	case reflect.String:
+		return jsonSchema{"type": "string"}
+	}
+	return jsonSchema{}
+}
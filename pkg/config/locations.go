@@ -0,0 +1,211 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// DefaultConfigFile The config file loaded when none is given
+const DefaultConfigFile = "${XDG_CONFIG_HOME}/importmanager/config.yaml"
+
+// DefaultCacheDirectory Where caches which can be rebuilt are kept when not set
+const DefaultCacheDirectory = "${XDG_CACHE_HOME}/importmanager"
+
+// PluginDirectory The name of the directory plugins are searched for in,
+// inside each data directory and the config directory
+const PluginDirectory = "plugins"
+
+// ConfigFile Get the absolute path of the config file to load
+//
+// Arguments:
+//
+// - filename string The config file given on the command line. May be empty
+//
+// Return:
+//
+// - string The config file, DefaultConfigFile if filename is empty
+func ConfigFile(filename string) string {
+	if filename == "" {
+		filename = DefaultConfigFile
+	}
+	expandPath(&filename)
+	if abs, err := filepath.Abs(filename); err == nil {
+		filename = abs
+	}
+	return filename
+}
+
+// PluginSearchPath List the directories searched for plugins, in order
+//
+// These are `pluginDirectory` if set, `$XDG_DATA_HOME/importmanager/plugins`,
+// `plugins` in the directory of the config file and finally
+// `importmanager/plugins` in each of `$XDG_DATA_DIRS`. A relative
+// `pluginDirectory` is taken from the directory of the config file.
+//
+// Arguments:
+//
+// - pluginDirectory string The configured plugin directory. May be empty
+// - configFile      string The config file
+//
+// Return:
+//
+// - []string The directories to search
+func PluginSearchPath(pluginDirectory, configFile string) (directories []string) {
+	directories = make([]string, 0)
+	var configDirectory string = filepath.Dir(ConfigFile(configFile))
+	if pluginDirectory != "" {
+		expandPath(&pluginDirectory)
+		if !filepath.IsAbs(pluginDirectory) {
+			pluginDirectory = filepath.Join(configDirectory, pluginDirectory)
+		}
+		directories = append(directories, filepath.Clean(pluginDirectory))
+	}
+
+	var data, _ = lookupVariable("XDG_DATA_HOME")
+	var candidates []string = []string{
+		filepath.Join(data, "importmanager", PluginDirectory),
+		filepath.Join(configDirectory, PluginDirectory),
+	}
+	var dirs, _ = lookupVariable("XDG_DATA_DIRS")
+	for _, dir := range strings.Split(dirs, ":") {
+		if dir != "" {
+			candidates = append(candidates, filepath.Join(dir, "importmanager", PluginDirectory))
+		}
+	}
+	for _, candidate := range candidates {
+		if !contains(candidate, directories) {
+			directories = append(directories, candidate)
+		}
+	}
+	return
+}
+
+// Defaults Get a configuration holding only the default locations
+//
+// This is used to report where files would be kept when the config file
+// cannot be loaded.
+func Defaults(configFile string) *Config {
+	var c *Config = &Config{}
+	c.locate(ConfigFile(configFile))
+	return c
+}
+
+// locate Apply the defaults for the directories used by a configuration
+func (c *Config) locate(filename string) {
+	if c.StateDirectory == "" {
+		c.StateDirectory = DefaultStateDirectory
+	}
+	expandPath(&c.StateDirectory)
+
+	if c.Quarantine == "" {
+		c.Quarantine = filepath.Join(c.StateDirectory, "quarantine")
+	}
+	expandPath(&c.Quarantine)
+
+	if c.CacheDirectory == "" {
+		c.CacheDirectory = DefaultCacheDirectory
+	}
+	expandPath(&c.CacheDirectory)
+
+	c.plugins = PluginSearchPath(c.PluginPath, filename)
+	if c.PluginPath != "" {
+		c.PluginPath = c.plugins[0]
+	}
+}
+
+// PluginDirectories The directories searched for plugins, in order
+func (c *Config) PluginDirectories() []string {
+	return c.plugins
+}
+
+// findPlugin Search the plugin directories for a plugin
+//
+// Return:
+//
+// - string The path to the plugin or name if it was not found
+// - bool   False if the plugin was not found
+func (c *Config) findPlugin(name string) (string, bool) {
+	if filepath.IsAbs(name) {
+		_, err := os.Stat(name)
+		return name, err == nil
+	}
+	for _, dir := range c.plugins {
+		var candidate string = filepath.Join(dir, name)
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate, true
+		}
+	}
+	return name, false
+}
+
+// Sandbox Move every location a configuration writes to under root
+//
+// Watched paths, processor destinations, processor properties naming files
+// and the state, cache and quarantine directories are all re-rooted so the
+// configuration can be exercised without touching real files. Plugins and
+// mime directories are still read from where they are.
+//
+// Arguments:
+//
+// - root string The directory to use as the filesystem root
+//
+// Return:
+//
+// - void
+func (c *Config) Sandbox(root string) {
+	for i := range c.Paths {
+		c.Paths[i].Path = SandboxPath(root, c.Paths[i].Path)
+		for j := range c.Paths[i].Processors {
+			var q *Processor = &c.Paths[i].Processors[j]
+			if q.Path != "" {
+				q.Path = SandboxPath(root, q.Path)
+			}
+			c.sandboxProperties(root, q)
+		}
+	}
+	for i := range c.ContentIndex.Roots {
+		c.ContentIndex.Roots[i] = SandboxPath(root, c.ContentIndex.Roots[i])
+	}
+	c.StateDirectory = SandboxPath(root, c.StateDirectory)
+	c.Quarantine = SandboxPath(root, c.Quarantine)
+	c.CacheDirectory = SandboxPath(root, c.CacheDirectory)
+}
+
+// sandboxProperties Re-root the properties of a processor which name files
+//
+// These are the properties its handler declares as paths and `setexec`
+// when it is given a path rather than a bool.
+func (c *Config) sandboxProperties(root string, q *Processor) {
+	var schema, _, _ = c.propertySchema(q.Handler)
+	for name, value := range q.Properties {
+		if value == "" {
+			continue
+		}
+		if _, err := strconv.ParseBool(value); schema[name].Type == PropertyPath || (name == "setexec" && err != nil) {
+			q.Properties[name] = SandboxPath(root, value)
+		}
+	}
+}
+
+// SandboxPath Get where a path is found inside a sandbox root
+//
+// `~` and `${NAME}` are expanded and relative paths are taken from the
+// working directory before the path is re-rooted.
+//
+// Arguments:
+//
+// - root string The directory used as the filesystem root
+// - path string The path outside of the sandbox
+//
+// Return:
+//
+// - string The path inside root
+func SandboxPath(root, path string) string {
+	expandPath(&path)
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	return filepath.Join(root, path)
+}
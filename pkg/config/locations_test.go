@@ -0,0 +1,50 @@
+package config
+
+import (
+	"testing"
+)
+
+func TestSandboxReRootsPathProperties(t *testing.T) {
+	var (
+		root   string  = "/sandbox"
+		config *Config = &Config{
+			StateDirectory: "/state",
+			Quarantine:     "/state/quarantine",
+			CacheDirectory: "/cache",
+			schemas: map[string]PropertySchema{
+				"/plugins/sync.sh": {"target": {Type: PropertyPath}, "name": {Type: PropertyString}},
+			},
+			Paths: []Path{{
+				Path: "/home/user/Downloads",
+				Processors: []Processor{
+					{Handler: "copy", Path: "/home/user/bin", Properties: map[string]string{"setexec": "/home/user/bin/tool"}},
+					{Handler: "install", Path: "/home/user/bin", Properties: map[string]string{"setexec": "true"}},
+					{Handler: "/plugins/sync.sh", Path: "/remote", Properties: map[string]string{"target": "/mnt/sync", "name": "/not/a/path"}},
+				},
+			}},
+		}
+	)
+	config.Sandbox(root)
+
+	var tests = []struct {
+		name     string
+		got      string
+		expected string
+	}{
+		{name: "watched path", got: config.Paths[0].Path, expected: "/sandbox/home/user/Downloads"},
+		{name: "destination", got: config.Paths[0].Processors[0].Path, expected: "/sandbox/home/user/bin"},
+		{name: "setexec path", got: config.Paths[0].Processors[0].Properties["setexec"], expected: "/sandbox/home/user/bin/tool"},
+		{name: "setexec bool", got: config.Paths[0].Processors[1].Properties["setexec"], expected: "true"},
+		{name: "plugin path property", got: config.Paths[0].Processors[2].Properties["target"], expected: "/sandbox/mnt/sync"},
+		{name: "plugin string property", got: config.Paths[0].Processors[2].Properties["name"], expected: "/not/a/path"},
+		{name: "state directory", got: config.StateDirectory, expected: "/sandbox/state"},
+		{name: "cache directory", got: config.CacheDirectory, expected: "/sandbox/cache"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.got != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, tt.got)
+			}
+		})
+	}
+}
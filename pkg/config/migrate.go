@@ -0,0 +1,200 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+	yaml3 "gopkg.in/yaml.v3"
+)
+
+// CurrentVersion The version of the config file format read by this release
+//
+// Files without a `version` are version 1.
+const CurrentVersion = 2
+
+// migration Upgrades a config file from one version to the next
+type migration struct {
+	from  int
+	apply func(root *yaml3.Node) []string
+}
+
+// migrations Each change to the config file format, oldest first
+var migrations = []migration{
+	{from: 1, apply: migrateBufferSize},
+}
+
+// Migrate Upgrade a config file to CurrentVersion
+//
+// Comments and the order of keys are kept so the result can be written back
+// over the original file.
+//
+// Arguments:
+//
+// - data []byte The contents of the config file
+//
+// Return:
+//
+// - []byte   The upgraded config file. The same as data if nothing changed
+// - []string A description of each change made other than the version
+// - error    Any error parsing the file or if it is newer than CurrentVersion
+func Migrate(data []byte) (upgraded []byte, changes []string, err error) {
+	changes = make([]string, 0)
+	var document yaml3.Node
+	if err = yaml3.Unmarshal(data, &document); err != nil {
+		return
+	}
+	if len(document.Content) == 0 || document.Content[0].Kind != yaml3.MappingNode {
+		return data, changes, nil
+	}
+
+	var (
+		root    *yaml3.Node = document.Content[0]
+		version int         = 1
+	)
+	if v := mappingValue(root, "version"); v != nil {
+		if version, err = strconv.Atoi(v.Value); err != nil {
+			return nil, nil, fmt.Errorf("invalid version '%s'", v.Value)
+		}
+	}
+	if version > CurrentVersion {
+		return nil, nil, fmt.Errorf("config version %d is newer than the supported version %d", version, CurrentVersion)
+	}
+	if version == CurrentVersion {
+		return data, changes, nil
+	}
+
+	for _, m := range migrations {
+		if m.from >= version {
+			changes = append(changes, m.apply(root)...)
+		}
+	}
+	setMappingValue(root, "version", strconv.Itoa(CurrentVersion), true)
+
+	var buf bytes.Buffer
+	var encoder *yaml3.Encoder = yaml3.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err = encoder.Encode(&document); err != nil {
+		return
+	}
+	encoder.Close()
+	return buf.Bytes(), changes, nil
+}
+
+// MigrateFile Upgrade a config file to CurrentVersion and write it back
+//
+// Arguments:
+//
+// - filename string The file to upgrade
+// - write    bool   If false the file is not changed
+//
+// Return:
+//
+// - []byte   The upgraded file
+// - []string A description of each change made other than the version
+// - bool     True if the file was out of date
+// - error    Any error reading, upgrading or writing the file
+func MigrateFile(filename string, write bool) (upgraded []byte, changes []string, outdated bool, err error) {
+	var data []byte
+	if data, err = ioutil.ReadFile(filename); err != nil {
+		return
+	}
+	if upgraded, changes, err = Migrate(data); err != nil {
+		err = fmt.Errorf("%s: %s", filename, err.Error())
+		return
+	}
+	if outdated = !bytes.Equal(upgraded, data); outdated && write {
+		err = replaceFile(filename, upgraded)
+	}
+	return
+}
+
+// Sources List a config file and every file merged into it
+func Sources(configFile string) (sources []string, err error) {
+	var data []byte
+	if data, err = ioutil.ReadFile(configFile); err != nil {
+		return
+	}
+	_, sources, err = compose(configFile, data)
+	return
+}
+
+// upgrade Migrate a config file in memory, warning about each change
+func upgrade(filename string, data []byte) ([]byte, error) {
+	upgraded, changes, err := Migrate(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err.Error())
+	}
+	if len(changes) > 0 {
+		for _, change := range changes {
+			log.Warnf("%s: %s", filename, change)
+		}
+		log.Warnf("%s is out of date. Run `importmanager config migrate` to update it", filename)
+	}
+	return upgraded, nil
+}
+
+// migrateBufferSize Version 1 to 2. `bufferSize` was the size of the buffer
+// for each path. The worker pool is now shared and sized by `workers`.
+//
+// The buffer size is not carried over as it was usually far larger than
+// the number of files which should be handled at once.
+func migrateBufferSize(root *yaml3.Node) (changes []string) {
+	var size *yaml3.Node = mappingValue(root, "bufferSize")
+	if size == nil {
+		return
+	}
+	deleteMappingKey(root, "bufferSize")
+	var change string = fmt.Sprintf("bufferSize %s was removed. Set workers to size the shared worker pool (default %d)", size.Value, DefaultWorkers)
+	if mappingValue(root, "workers") != nil {
+		change = fmt.Sprintf("bufferSize %s was removed as workers is set", size.Value)
+	}
+	changes = append(changes, change)
+	return
+}
+
+// mappingValue Get the value of a key in a mapping node or nil if not set
+func mappingValue(node *yaml3.Node, key string) *yaml3.Node {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// setMappingValue Set the value of a key in a mapping node
+//
+// A key which is not set yet is added at the end of the mapping, or at the
+// start if first is true. A key added at the start takes the comment above
+// the mapping so it stays at the top of the file.
+func setMappingValue(node *yaml3.Node, key, value string, first bool) {
+	if v := mappingValue(node, key); v != nil {
+		v.Kind, v.Tag, v.Value = yaml3.ScalarNode, "", value
+		return
+	}
+	var pair []*yaml3.Node = []*yaml3.Node{
+		{Kind: yaml3.ScalarNode, Value: key},
+		{Kind: yaml3.ScalarNode, Value: value},
+	}
+	if first {
+		if len(node.Content) > 0 {
+			pair[0].HeadComment, node.Content[0].HeadComment = node.Content[0].HeadComment, ""
+		}
+		node.Content = append(pair, node.Content...)
+		return
+	}
+	node.Content = append(node.Content, pair...)
+}
+
+// deleteMappingKey Remove a key and its value from a mapping node
+func deleteMappingKey(node *yaml3.Node, key string) {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			node.Content = append(node.Content[:i], node.Content[i+2:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMigrate(t *testing.T) {
+	var tests = []struct {
+		name     string
+		input    string
+		expected string
+		changes  []string
+	}{
+		{
+			name:     "current version is unchanged",
+			input:    "version: 2\npaths: []\n",
+			expected: "version: 2\npaths: []\n",
+		},
+		{
+			name:     "version is added",
+			input:    "paths: []\n",
+			expected: "version: 2\npaths: []\n",
+		},
+		{
+			name:     "bufferSize is dropped rather than becoming workers",
+			input:    "bufferSize: 50\npaths: []\n",
+			expected: "version: 2\npaths: []\n",
+			changes:  []string{"bufferSize 50 was removed"},
+		},
+		{
+			name:     "bufferSize is dropped when workers is set",
+			input:    "bufferSize: 50\nworkers: 2\n",
+			expected: "version: 2\nworkers: 2\n",
+			changes:  []string{"bufferSize 50 was removed as workers is set"},
+		},
+		{
+			name:     "file comment stays at the top",
+			input:    "# importmanager\npaths: [] # none yet\n",
+			expected: "# importmanager\nversion: 2\npaths: [] # none yet\n",
+		},
+		{
+			name:     "separated file comment stays at the top",
+			input:    "# importmanager\n\npaths: []\n",
+			expected: "# importmanager\n\nversion: 2\npaths: []\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upgraded, changes, err := Migrate([]byte(tt.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(upgraded) != tt.expected {
+				t.Errorf("expected\n%s\ngot\n%s", tt.expected, upgraded)
+			}
+			if len(changes) != len(tt.changes) {
+				t.Fatalf("expected %d changes, got %q", len(tt.changes), changes)
+			}
+			for i, want := range tt.changes {
+				if !strings.HasPrefix(changes[i], want) {
+					t.Errorf("expected %q to start with %q", changes[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestMigrateRejectsNewerVersions(t *testing.T) {
+	if _, _, err := Migrate([]byte("version: 99\n")); err == nil {
+		t.Error("expected an error for a version newer than CurrentVersion")
+	}
+}
@@ -0,0 +1,406 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Types of processor property
+const (
+	// PropertyString Any text (default)
+	PropertyString = "string"
+
+	// PropertyBool `true` or `false`
+	PropertyBool = "bool"
+
+	// PropertyInteger A whole number
+	PropertyInteger = "integer"
+
+	// PropertyOctal An octal number such as `027`
+	PropertyOctal = "octal"
+
+	// PropertyPath A file or directory. `~` and `${NAME}` are expanded
+	PropertyPath = "path"
+)
+
+// SchemaSuffix Replaces the extension of a plugin to give the name of the
+// file declaring its properties
+const SchemaSuffix = ".schema.yaml"
+
+// commonProperties Properties read by every built-in handler
+var commonProperties = PropertySchema{
+	"exif-date": {
+		Type:        PropertyString,
+		Description: "The exif field images are dated by for `{{.date}}`. Defaults to `CreateDate`",
+	},
+	"include-date-directory": {
+		Type:        PropertyBool,
+		Description: "Populate `{{.date}}`. Implied when the path uses it",
+	},
+	"extension-directory": {
+		Type:        PropertyBool,
+		Description: "Populate `{{.ext}}`. Always available",
+	},
+	"uppercase-extension-directory": {
+		Type:        PropertyBool,
+		Description: "Populate `{{.ucext}}`. Implied when the path uses it",
+	},
+	"sanitise": {
+		Type:        PropertyString,
+		Description: "Clean destination filenames for the target filesystem",
+		Enum:        []string{"posix", "fat", "portable"},
+	},
+	"sanitise-replacement": {
+		Type:        PropertyString,
+		Default:     "_",
+		Description: "The string used in place of forbidden characters",
+	},
+	"chmod": {
+		Type:        PropertyString,
+		Description: "The mode to set on the destination. See `man chmod`",
+	},
+	"chown": {
+		Type:        PropertyString,
+		Description: "The owner of the destination in the format `username:groupname`",
+	},
+	"setexec": {
+		Type:        PropertyString,
+		Description: "A bool or path. `true` sets the executable bit on the destination, a path sets it on that file instead",
+	},
+}
+
+// transferProperties Properties read by handlers which copy files
+var transferProperties = PropertySchema{
+	"strip-extension": {
+		Type:        PropertyBool,
+		Default:     "false",
+		Description: "Remove the extension from the destination filename",
+	},
+	"lowercase-destination": {
+		Type:        PropertyBool,
+		Default:     "false",
+		Description: "Lower case the destination filename",
+	},
+	"collapse-duplicates": {
+		Type:        PropertyBool,
+		Default:     "false",
+		Description: "Strip browser duplicate suffixes such as `file (1).pdf`",
+	},
+	"compare-sha": {
+		Type:        PropertyBool,
+		Default:     "false",
+		Description: "Compare checksums when the destination exists and keep both files if they differ",
+	},
+	"hash-algorithm": {
+		Type:        PropertyString,
+		Description: "The checksum used by `compare-sha` and `collapse-duplicates`. Defaults to `hashAlgorithm`",
+		Enum:        []string{"sha256", "blake3", "xxh3"},
+	},
+	"dedupe": {
+		Type:        PropertyString,
+		Description: "What to do when the content index holds a file with the same content",
+		Enum:        []string{"delete", "hardlink", "symlink", "report"},
+	},
+}
+
+// preserveProperties Properties controlling which attributes of the source
+// are kept
+var preserveProperties = PropertySchema{
+	"preserve-times": {
+		Type:        PropertyBool,
+		Default:     "true",
+		Description: "Keep the access and modification times of the source",
+	},
+	"preserve-mode": {
+		Type:        PropertyBool,
+		Default:     "true",
+		Description: "Create the destination with the permission bits of the source",
+	},
+	"preserve-xattrs": {
+		Type:        PropertyBool,
+		Default:     "true",
+		Description: "Copy `user.*` extended attributes",
+	},
+	"preserve-acls": {
+		Type:        PropertyBool,
+		Default:     "true",
+		Description: "Copy POSIX ACLs",
+	},
+	"umask": {
+		Type:        PropertyOctal,
+		Description: "A umask applied to the destination mode in place of the process umask",
+	},
+}
+
+// extractProperties Properties read by the `extract` handler
+var extractProperties = PropertySchema{
+	"cleanup-source": {
+		Type:        PropertyBool,
+		Default:     "false",
+		Description: "Delete the archive once it has been extracted",
+	},
+}
+
+// builtinProperties The properties accepted by each built-in handler
+var builtinProperties = map[string]PropertySchema{
+	"copy":    combine(commonProperties, transferProperties, preserveProperties),
+	"move":    combine(commonProperties, transferProperties, preserveProperties),
+	"install": combine(commonProperties, transferProperties, preserveProperties),
+	"extract": combine(commonProperties, preserveProperties, extractProperties),
+	"delete":  combine(commonProperties),
+}
+
+// combine Merge several sets of properties into one
+func combine(schemas ...PropertySchema) PropertySchema {
+	var combined PropertySchema = make(PropertySchema)
+	for _, schema := range schemas {
+		for name, property := range schema {
+			combined[name] = property
+		}
+	}
+	return combined
+}
+
+// propertySchema Get the properties accepted by a handler
+//
+// Plugins declare their properties in a file next to the plugin, named after
+// it with the extension replaced by `.schema.yaml`.
+//
+// Arguments:
+//
+// - handler string The name of a built-in handler or the path to a plugin
+//
+// Return:
+//
+// - PropertySchema The properties accepted by the handler
+// - bool           False if the handler is a plugin without a schema
+// - error          Any error reading the schema of a plugin
+func (c *Config) propertySchema(handler string) (PropertySchema, bool, error) {
+	if schema, ok := builtinProperties[strings.ToLower(handler)]; ok {
+		return schema, true, nil
+	}
+	if c.schemas == nil {
+		c.schemas = make(map[string]PropertySchema)
+	}
+	if schema, ok := c.schemas[handler]; ok {
+		return schema, schema != nil, nil
+	}
+
+	var filename, found = c.findPlugin(handler)
+	if !found {
+		return nil, false, nil
+	}
+	var schema, err = readPluginSchema(strings.TrimSuffix(filename, filepath.Ext(filename)) + SchemaSuffix)
+	if err != nil {
+		return nil, false, err
+	}
+	c.schemas[handler] = schema
+	return schema, schema != nil, nil
+}
+
+// readPluginSchema Read the properties declared by a plugin
+//
+// The properties common to all handlers are added to those declared. A
+// missing file is not an error. The schema returned is nil.
+func readPluginSchema(filename string) (PropertySchema, error) {
+	var data, err = ioutil.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var schema pluginSchema
+	if err = yaml.UnmarshalStrict(data, &schema); err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err.Error())
+	}
+	if schema.Properties == nil {
+		schema.Properties = make(PropertySchema)
+	}
+	for name, property := range schema.Properties {
+		switch property.Type {
+		case "":
+			property.Type = PropertyString
+			schema.Properties[name] = property
+		case PropertyString, PropertyBool, PropertyInteger, PropertyOctal, PropertyPath:
+		default:
+			return nil, fmt.Errorf("%s: property %s has unknown type '%s'", filename, name, property.Type)
+		}
+	}
+	// Post processing properties apply to plugins as well
+	return combine(commonProperties, schema.Properties), nil
+}
+
+// applyDefaults Set each property a processor does not set to the default
+// declared by its handler
+func (c *Config) applyDefaults(q *Processor) error {
+	var schema, ok, err = c.propertySchema(q.Handler)
+	if err != nil || !ok {
+		return err
+	}
+	for name, property := range schema {
+		if property.Default == "" {
+			continue
+		}
+		if q.Properties == nil {
+			q.Properties = make(map[string]string)
+		}
+		if _, set := q.Properties[name]; !set {
+			q.Properties[name] = property.Default
+		}
+	}
+	return nil
+}
+
+// expandProperties Expand `~` and `${NAME}` in the properties declared
+// as paths by the handler of a processor
+//
+// Other values are passed through unchanged.
+func (c *Config) expandProperties(q *Processor) error {
+	var schema, ok, err = c.propertySchema(q.Handler)
+	if err != nil || !ok {
+		return err
+	}
+	for name, value := range q.Properties {
+		if schema[name].Type == PropertyPath {
+			expandPath(&value)
+			q.Properties[name] = value
+		}
+	}
+	return nil
+}
+
+// check Validate a set of properties against their declarations
+//
+// Return:
+//
+// - []string A description of each unknown property or invalid value
+func (s PropertySchema) check(properties map[string]string) (problems []string) {
+	problems = make([]string, 0)
+	var names []string = make([]string, 0, len(s))
+	for name := range s {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var keys []string = make([]string, 0, len(properties))
+	for key := range properties {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		var property, ok = s[key]
+		if !ok {
+			var problem string = fmt.Sprintf("unknown property '%s'", key)
+			if suggestion := suggest(key, names); suggestion != "" {
+				problem += fmt.Sprintf(", did you mean '%s'?", suggestion)
+			}
+			problems = append(problems, problem)
+			continue
+		}
+		if problem := property.check(properties[key]); problem != "" {
+			problems = append(problems, fmt.Sprintf("property '%s' %s", key, problem))
+		}
+	}
+	return
+}
+
+// check Validate the value of a property
+//
+// Return:
+//
+// - string Why the value is invalid or empty if it is valid
+func (p Property) check(value string) string {
+	var err error
+	switch p.Type {
+	case PropertyBool:
+		_, err = strconv.ParseBool(value)
+	case PropertyInteger:
+		_, err = strconv.Atoi(value)
+	case PropertyOctal:
+		_, err = strconv.ParseUint(value, 8, 32)
+	}
+	if err != nil {
+		return fmt.Sprintf("must be of type %s, got '%s'", p.Type, value)
+	}
+
+	if len(p.Enum) == 0 {
+		return ""
+	}
+	for _, allowed := range p.Enum {
+		if strings.EqualFold(value, allowed) {
+			return ""
+		}
+	}
+	var problem string = fmt.Sprintf("must be one of %s, got '%s'", strings.Join(p.Enum, ", "), value)
+	if suggestion := suggest(value, p.Enum); suggestion != "" {
+		problem += fmt.Sprintf(", did you mean '%s'?", suggestion)
+	}
+	return problem
+}
+
+// suggest Find the closest of a set of names to a misspelt one
+//
+// Return:
+//
+// - string The closest name or empty if none is close enough to be a typo
+func suggest(misspelt string, names []string) (closest string) {
+	var (
+		best  int = -1
+		limit int = len(misspelt) / 3
+	)
+	if limit < 2 {
+		limit = 2
+	}
+	for _, name := range names {
+		var d int = distance(strings.ToLower(misspelt), strings.ToLower(name))
+		if d <= limit && (best < 0 || d < best) {
+			best, closest = d, name
+		}
+	}
+	return
+}
+
+// distance The Levenshtein distance between two strings. The number of
+// single character insertions, deletions or substitutions needed to change
+// one into the other
+func distance(a, b string) int {
+	var (
+		s, t     []rune = []rune(a), []rune(b)
+		previous []int  = make([]int, len(t)+1)
+		current  []int  = make([]int, len(t)+1)
+	)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(s); i++ {
+		current[0] = i
+		for j := 1; j <= len(t); j++ {
+			var cost int = 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+			current[j] = smallest(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(t)]
+}
+
+func smallest(values ...int) int {
+	var result int = values[0]
+	for _, v := range values[1:] {
+		if v < result {
+			result = v
+		}
+	}
+	return result
+}
@@ -0,0 +1,56 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBuiltinPropertiesCheck(t *testing.T) {
+	var tests = []struct {
+		name       string
+		handler    string
+		properties map[string]string
+		problems   []string
+	}{
+		{
+			name:       "extension directory",
+			handler:    "move",
+			properties: map[string]string{"extension-directory": "true"},
+		},
+		{
+			name:       "setexec as a bool",
+			handler:    "install",
+			properties: map[string]string{"setexec": "true"},
+		},
+		{
+			name:       "setexec as a path",
+			handler:    "copy",
+			properties: map[string]string{"setexec": "/home/user/bin/tool"},
+		},
+		{
+			name:       "invalid bool",
+			handler:    "copy",
+			properties: map[string]string{"compare-sha": "maybe"},
+			problems:   []string{"property 'compare-sha'"},
+		},
+		{
+			name:       "misspelt property",
+			handler:    "copy",
+			properties: map[string]string{"compare-shaa": "true"},
+			problems:   []string{"did you mean 'compare-sha'?"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var problems []string = builtinProperties[tt.handler].check(tt.properties)
+			if len(problems) != len(tt.problems) {
+				t.Fatalf("expected %d problems, got %q", len(tt.problems), problems)
+			}
+			for i, want := range tt.problems {
+				if !strings.Contains(problems[i], want) {
+					t.Errorf("expected %q to contain %q", problems[i], want)
+				}
+			}
+		})
+	}
+}
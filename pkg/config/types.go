@@ -2,6 +2,7 @@ package config
 
 import (
 	"sync"
+	"sync/atomic"
 	"time"
 
 	m "github.com/mproffitt/importmanager/pkg/mime"
@@ -9,36 +10,147 @@ import (
 
 // Path A path object for processors
 type Path struct {
-	Path       string      `yaml:"path"`
-	Processors []Processor `yaml:"processors"`
+	Path            string        `yaml:"path"`
+	Processors      []Processor   `yaml:"processors"`
+	Readiness       Readiness     `yaml:"readiness"`
+	Recursive       bool          `yaml:"recursive"`
+	MaxDepth        int           `yaml:"maxDepth"`
+	Ignore          []string      `yaml:"ignore"`
+	IncludeHidden   *bool         `yaml:"includeHidden"`
+	Directories     string        `yaml:"directories"`
+	Workers         int           `yaml:"workers"`
+	CreateIfMissing bool          `yaml:"createIfMissing"`
+	Watcher         string        `yaml:"watcher"`
+	PollInterval    time.Duration `yaml:"pollInterval"`
+	Mode            string        `yaml:"mode"`
+}
+
+// Readiness How to decide a file is complete before it is handled
+type Readiness struct {
+	Checks         []string      `yaml:"checks"`
+	Samples        int           `yaml:"samples"`
+	SampleInterval time.Duration `yaml:"sampleInterval"`
 }
 
 // Config Global config for the application
+//
+// A Config is not changed once it has been loaded. Reloading the config
+// file creates a new one. See Manager.
 type Config struct {
-	sync.RWMutex
-	Paths           []Path        `yaml:"paths"`
-	DelayInSeconds  time.Duration `yaml:"delayInSeconds"`
-	CleanupZeroByte bool          `yaml:"cleanupZeroByte"`
-	PluginPath      string        `yaml:"pluginDirectory"`
-	BufferSize      int           `yaml:"bufferSize"`
-	LogLevel        string        `yaml:"logLevel"`
-	MimeDirectories []string      `yaml:"mimeDirectories"`
+	Version         int                  `yaml:"version"`
+	Mode            string               `yaml:"mode"`
+	Include         []string             `yaml:"include"`
+	Presets         map[string]Processor `yaml:"presets"`
+	Processors      []Processor          `yaml:"processors"`
+	Paths           []Path               `yaml:"paths"`
+	DelayInSeconds  time.Duration        `yaml:"delayInSeconds"`
+	CleanupZeroByte bool                 `yaml:"cleanupZeroByte"`
+	PluginPath      string               `yaml:"pluginDirectory"`
+	Workers         int                  `yaml:"workers"`
+	Limits          Limits               `yaml:"limits"`
+	Scheduling      Scheduling           `yaml:"scheduling"`
+	Journal         Journal              `yaml:"journal"`
+	Quarantine      string               `yaml:"quarantine"`
+	LoopDetection   LoopDetection        `yaml:"loopDetection"`
+	LogLevel        string               `yaml:"logLevel"`
+	MimeDirectories []string             `yaml:"mimeDirectories"`
+	StateDirectory  string               `yaml:"stateDirectory"`
+	HashAlgorithm   string               `yaml:"hashAlgorithm"`
+	ContentIndex    ContentIndex         `yaml:"contentIndex"`
+	ConfigBackups   int                  `yaml:"configBackups"`
+	CacheDirectory  string               `yaml:"cacheDirectory"`
 	pathHandler     handler
+	source          []byte
+	sources         []string
+	schemas         map[string]PropertySchema
+	plugins         []string
+}
+
+// Manager Holds the current configuration and reloads it when the config
+// file changes
+//
+// Each reload is parsed and validated into a new Config which replaces the
+// current one in a single step. An invalid file leaves the current Config
+// in place.
+type Manager struct {
+	sync.Mutex
+	filename    string
+	pathHandler handler
+	current     atomic.Value
+	subscribers []chan bool
+	failures    []chan error
+}
+
+// ContentIndex Settings for the library wide content index
+type ContentIndex struct {
+	Enabled        bool          `yaml:"enabled"`
+	RescanInterval time.Duration `yaml:"rescanInterval"`
+	Roots          []string      `yaml:"roots"`
+}
+
+// Limits Optional caps on concurrent jobs within the global worker pool
+type Limits struct {
+	Handlers  map[string]int `yaml:"handlers"`
+	PerDevice int            `yaml:"perDevice"`
+}
+
+// Scheduling The order queued files are handled in
+type Scheduling struct {
+	Policy string        `yaml:"policy"`
+	Aging  time.Duration `yaml:"aging"`
+}
+
+// Journal Settings for the job journal
+type Journal struct {
+	Retention time.Duration `yaml:"retention"`
+}
+
+// Retry How often a failing processor is retried before the file is
+// quarantined
+type Retry struct {
+	Attempts   int           `yaml:"attempts" json:"attempts,omitempty"`
+	Backoff    time.Duration `yaml:"backoff" json:"backoff,omitempty"`
+	MaxBackoff time.Duration `yaml:"maxBackoff" json:"maxBackoff,omitempty"`
+}
+
+// LoopDetection Limits on how often the same content may be handled
+type LoopDetection struct {
+	MaxHops int           `yaml:"maxHops"`
+	Window  time.Duration `yaml:"window"`
 }
 
 // Processor How to handle a particular file type
 type Processor struct {
-	Type       string            `yaml:"type"`
-	Path       string            `yaml:"path"`
-	Handler    string            `yaml:"handler"`
-	Properties map[string]string `yaml:"properties"`
-	Negated    bool
+	Type       string            `yaml:"type" json:"type"`
+	Path       string            `yaml:"path" json:"path"`
+	Handler    string            `yaml:"handler" json:"handler"`
+	Use        string            `yaml:"use" json:"use,omitempty"`
+	Properties map[string]string `yaml:"properties" json:"properties,omitempty"`
+	Priority   int               `yaml:"priority" json:"priority,omitempty"`
+	Retry      Retry             `yaml:"retry" json:"retry,omitempty"`
+	Negated    bool              `json:"negated,omitempty"`
+}
+
+// Property The declaration of a property accepted by a handler
+type Property struct {
+	Type        string   `yaml:"type"`
+	Default     string   `yaml:"default"`
+	Description string   `yaml:"description"`
+	Enum        []string `yaml:"enum"`
+}
+
+// PropertySchema The properties accepted by a handler, by name
+type PropertySchema map[string]Property
+
+// pluginSchema The contents of the file declaring the properties of a plugin
+type pluginSchema struct {
+	Properties PropertySchema `yaml:"properties"`
 }
 
 // handler type to allow the passing of the handler.Handle function into the dryrun
 //
 // See: `handle.Handle`
-type handler func(path string, details m.Details, processors []Processor, czb bool) (err error)
+type handler func(path string, details m.Details, watched Path, czb bool) (err error)
 
 // Lockable type to handle dry run paths
 type dryRun struct {
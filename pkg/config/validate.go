@@ -0,0 +1,207 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// validate Check a loaded configuration for mistakes
+//
+// All problems found are returned together so they can be fixed at once.
+// This includes chains of processors which would move files back into a
+// path they have already been handled in.
+func (c *Config) validate() error {
+	var (
+		problems []string        = make([]string, 0)
+		seen     map[string]bool = make(map[string]bool)
+	)
+	for _, p := range c.Paths {
+		if p.Path == "" {
+			problems = append(problems, "a path has no `path` set")
+			continue
+		}
+		if seen[p.Path] {
+			problems = append(problems, fmt.Sprintf("path %s is defined more than once", p.Path))
+		}
+		seen[p.Path] = true
+
+		switch p.Directories {
+		case DirectoryIgnore, DirectoryRecurse, DirectoryWhole, DirectoryDominant:
+		default:
+			problems = append(problems, fmt.Sprintf("path %s has unknown directories mode '%s'", p.Path, p.Directories))
+		}
+		switch p.Watcher {
+		case WatcherAuto, WatcherInotify, WatcherPoll:
+		default:
+			problems = append(problems, fmt.Sprintf("path %s has unknown watcher '%s'", p.Path, p.Watcher))
+		}
+		switch p.Mode {
+		case ModeHandle, ModeObserve, c.Mode:
+		default:
+			problems = append(problems, fmt.Sprintf("path %s has unknown mode '%s'", p.Path, p.Mode))
+		}
+
+		for i, q := range p.Processors {
+			if q.Type == "" {
+				problems = append(problems, fmt.Sprintf("processor %d of path %s has no type", i+1, p.Path))
+			}
+			if q.Handler == "" {
+				problems = append(problems, fmt.Sprintf("processor %d of path %s has no handler", i+1, p.Path))
+			}
+			if q.Path == "" && !strings.EqualFold(q.Handler, "delete") {
+				problems = append(problems, fmt.Sprintf("processor %d of path %s has no destination path", i+1, p.Path))
+			}
+			if q.Handler != "" && !DefaultHandlers.IsBuiltIn(q.Handler) {
+				if _, found := c.findPlugin(q.Handler); !found {
+					var problem string = fmt.Sprintf("processor %d of path %s has handler '%s' which is neither built in nor a plugin in %s",
+						i+1, p.Path, q.Handler, strings.Join(c.plugins, ", "))
+					if suggestion := suggest(q.Handler, DefaultHandlers); suggestion != "" {
+						problem += fmt.Sprintf(", did you mean '%s'?", suggestion)
+					}
+					problems = append(problems, problem)
+				}
+			}
+			if schema, ok, _ := c.propertySchema(q.Handler); ok {
+				for _, problem := range schema.check(q.Properties) {
+					problems = append(problems, fmt.Sprintf("processor %d of path %s: %s", i+1, p.Path, problem))
+				}
+			}
+		}
+	}
+
+	var locations []string = []string{c.StateDirectory, c.CacheDirectory, c.PluginPath, c.Quarantine}
+	locations = append(locations, c.MimeDirectories...)
+	locations = append(locations, c.ContentIndex.Roots...)
+	for _, p := range c.Paths {
+		locations = append(locations, p.Path)
+		for _, q := range p.Processors {
+			locations = append(locations, q.Path)
+			if schema, ok, _ := c.propertySchema(q.Handler); ok {
+				for name, value := range q.Properties {
+					if schema[name].Type == PropertyPath {
+						locations = append(locations, value)
+					}
+				}
+			}
+		}
+	}
+	for _, location := range locations {
+		if name, ok := unresolved(location); ok {
+			problems = append(problems, fmt.Sprintf("%s uses undefined variable %s", location, name))
+		}
+	}
+
+	switch c.Mode {
+	case ModeHandle, ModeObserve:
+	default:
+		problems = append(problems, fmt.Sprintf("unknown mode '%s'", c.Mode))
+	}
+
+	switch c.Scheduling.Policy {
+	case PolicyFIFO, PolicySmallestFirst:
+	default:
+		problems = append(problems, fmt.Sprintf("unknown scheduling policy '%s'", c.Scheduling.Policy))
+	}
+
+	for _, cycle := range c.cycles() {
+		problems = append(problems, "files would loop between paths: "+cycle)
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration\n  - %s", strings.Join(problems, "\n  - "))
+	}
+	return nil
+}
+
+// cycles Find chains of processors which write files back into a path they
+// were handled in
+//
+// A chain is only followed while the types of its processors overlap. For
+// example `image` followed by `image/jpeg` is followed but `image` followed
+// by `video` is not. Subclasses are not considered so this does not find
+// every loop. Loops which are missed are caught at runtime.
+//
+// Return:
+//
+// - []string A description of each loop found
+func (c *Config) cycles() (found []string) {
+	found = make([]string, 0)
+	for start := range c.Paths {
+		var visited map[int]bool = map[int]bool{start: true}
+		c.follow(start, start, "*", []string{c.Paths[start].Path}, visited, &found)
+	}
+	return
+}
+
+// follow Walk the processors of a path looking for a route back to start
+//
+// Each loop is only reported from the first of its paths in the config.
+func (c *Config) follow(start, current int, kind string, route []string, visited map[int]bool, found *[]string) {
+	for _, q := range c.Paths[current].Processors {
+		if q.Negated || strings.EqualFold(q.Handler, "delete") {
+			continue
+		}
+		var narrowed, ok = overlap(kind, q.Type)
+		if !ok {
+			continue
+		}
+		for next := start; next < len(c.Paths); next++ {
+			if !reaches(q, c.Paths[next]) {
+				continue
+			}
+			var hop string = fmt.Sprintf("-[%s %s]-> %s", q.Handler, q.Type, c.Paths[next].Path)
+			if next == start {
+				*found = append(*found, strings.Join(append(route, hop), " "))
+				continue
+			}
+			if visited[next] {
+				continue
+			}
+			visited[next] = true
+			c.follow(start, next, narrowed, append(route, hop), visited, found)
+			delete(visited, next)
+		}
+	}
+}
+
+// reaches Test whether a processor writes into a watched path
+//
+// Files written into a directory below the watched path are only seen when
+// the path is recursive.
+func reaches(q Processor, p Path) bool {
+	var (
+		root      string = StaticRoot(q.Path)
+		templated bool   = root != filepath.Clean(q.Path)
+	)
+	if root == "" {
+		return false
+	}
+	if root == p.Path && !templated {
+		return true
+	}
+	if root == p.Path || strings.HasPrefix(root, p.Path+string(filepath.Separator)) {
+		return p.Recursive || p.Directories == DirectoryRecurse
+	}
+	return false
+}
+
+// overlap Find the types matched by both a and b
+//
+// Return:
+//
+// - string The narrower of the two types
+// - bool   False if no type is matched by both
+func overlap(a, b string) (string, bool) {
+	switch {
+	case a == "*" || a == b:
+		return b, true
+	case b == "*":
+		return a, true
+	case strings.HasPrefix(b, a+"/"):
+		return b, true
+	case strings.HasPrefix(a, b+"/"):
+		return a, true
+	}
+	return "", false
+}
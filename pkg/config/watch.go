@@ -2,69 +2,236 @@ package config
 
 import (
 	"context"
-	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	n "github.com/rjeczalik/notify"
 	log "github.com/sirupsen/logrus"
-	yaml "gopkg.in/yaml.v2"
 )
 
-func (c *Config) watch(ctx context.Context, filename string) {
+// ReloadDelay How long the config file must be unchanged before it is reloaded
+const ReloadDelay = 250 * time.Millisecond
+
+// WatchRetryInterval The first wait before the directory holding the config
+// file is watched again after failing
+const WatchRetryInterval = 1 * time.Second
+
+// MaxWatchRetryInterval The longest wait between attempts to watch the
+// directory holding the config file
+const MaxWatchRetryInterval = 60 * time.Second
+
+// NewManager Load a config file and reload it whenever it changes
+//
+// Arguments:
+//
+// - configFile  string  The full path to the config file to load
+// - pathHandler handler A custom function of type handler to call during dry-run
+//
+// Return:
+//
+// - *Manager A manager holding the loaded configuration
+// - error    Any error loading or validating the config file
+func NewManager(configFile string, pathHandler handler) (m *Manager, err error) {
+	var c *Config
+	if c, err = New(configFile, pathHandler); err != nil {
+		return
+	}
+	m = &Manager{
+		filename:    configFile,
+		pathHandler: pathHandler,
+	}
+	m.current.Store(c)
+	c.backup(configFile)
+	go m.watch(context.Background(), configFile)
+	return
+}
+
+// Current Get the configuration currently in use
+//
+// The returned Config must not be changed.
+func (m *Manager) Current() *Config {
+	return m.current.Load().(*Config)
+}
+
+// reload Replace the current configuration with the contents of the config file
+//
+// If the file cannot be parsed or is invalid, the current configuration is
+// kept and subscribers to Failures are told why.
+func (m *Manager) reload() {
+	c, err := parse(m.filename, m.pathHandler)
+	if err != nil {
+		log.Errorf("Config file %s was not reloaded. Keeping the last valid configuration - %s", m.filename, err.Error())
+		m.fail(err)
+		return
+	}
+	c.apply()
+	m.current.Store(c)
+	c.backup(m.filename)
+	m.publish()
+}
+
+// watch Reload the config file whenever it changes
+//
+// The directory holding the file is watched rather than the file itself,
+// along with the directories of any files it includes and `conf.d`.
+// This keeps the watch in place when an editor replaces the file, and a
+// file which is removed is loaded again as soon as it reappears. Nothing is
+// written in its place in the meantime. Changes are only loaded once the
+// file has been quiet for ReloadDelay so a half written file is not read.
+func (m *Manager) watch(ctx context.Context, filename string) {
 	log.Infof("Setting up watch for config file %s", filename)
-	events := n.Remove | n.Write | n.InModify | n.InCloseWrite
-	channel := make(chan n.EventInfo, 1)
-	if err := n.Watch(filename, channel, events); err != nil {
-		log.Fatal(err)
+	if abs, err := filepath.Abs(filename); err == nil {
+		filename = abs
+	}
+
+	var (
+		dir     string           = filepath.Dir(filename)
+		events  n.Event          = n.Create | n.Remove | n.Rename | n.Write | n.InCloseWrite
+		channel chan n.EventInfo = make(chan n.EventInfo, 10)
+		delay   time.Duration    = WatchRetryInterval
+		timer   *time.Timer      = time.NewTimer(ReloadDelay)
+	)
+	timer.Stop()
+
+	for {
+		err := n.Watch(dir, channel, events)
+		if err == nil {
+			break
+		}
+		log.Errorf("Unable to watch %s for config changes. Trying again in %s - %s", dir, delay, err.Error())
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > MaxWatchRetryInterval {
+			delay = MaxWatchRetryInterval
+		}
 	}
 	defer n.Stop(channel)
 
+	var watched []string = []string{dir}
+	m.rewatch(filename, channel, events, &watched)
+
 	for {
 		select {
 		case <-ctx.Done():
 			return
 
 		case ei := <-channel:
+			if !m.relevant(filename, ei.Path()) {
+				continue
+			}
 			switch ei.Event() {
 			// VIM is a special case and renames / removes the old buffer
-			// and recreates a new one in place. This means we need to
-			// set up a new watch on the file to ensure we track further
-			// updates to it.
-			case n.Rename:
-				fallthrough
-			case n.Remove:
-				var i int = 0
-				for {
-					if _, err := os.Stat(filename); err == nil {
-						break
-					}
-					if i == MaxRetries {
-						// If we got here and the config wasn't recreted
-						// create it with the last known config values
-						data, _ := yaml.Marshal(c)
-						ioutil.WriteFile(filename, data, 0)
-						break
-					}
-					i++
-					<-time.After(1 * time.Millisecond)
-				}
-				n.Stop(channel)
-				if err := n.Watch(filename, channel, events); err != nil {
-					log.Println(err)
+			// and recreates a new one in place. The new file is seen
+			// when it is created.
+			case n.Rename, n.Remove:
+				if _, err := os.Stat(filename); err != nil {
+					log.Warnf("Config file %s was removed. Waiting for it to reappear", filename)
+					timer.Stop()
+					continue
 				}
-				defer n.Stop(channel)
-				fallthrough
-			case n.Write:
-				fallthrough
-			case n.InModify:
-				fallthrough
-			case n.InCloseWrite:
-				if err := c.load(filename); err != nil {
-					log.Fatal("Unable to load config file", err)
-					return
+				if ei.Path() == filename {
+					continue
 				}
 			}
+			timer.Reset(ReloadDelay)
+
+		case <-timer.C:
+			m.reload()
+			m.rewatch(filename, channel, events, &watched)
+		}
+	}
+}
+
+// rewatch Watch the directories of any files the config is now read from
+//
+// Directories which are no longer used stay watched. Their events are
+// ignored.
+func (m *Manager) rewatch(filename string, channel chan n.EventInfo, events n.Event, watched *[]string) {
+	var (
+		dirs  []string = []string{filepath.Join(filepath.Dir(filename), FragmentDirectory)}
+		added bool
+	)
+	for _, source := range m.Current().sources {
+		dirs = append(dirs, filepath.Dir(source))
+	}
+	for _, dir := range dirs {
+		if contains(dir, *watched) {
+			continue
+		}
+		if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+			continue
+		}
+		if err := n.Watch(dir, channel, events); err != nil {
+			log.Errorf("Unable to watch %s for config changes - %s", dir, err.Error())
+			continue
+		}
+		*watched, added = append(*watched, dir), true
+	}
+	if added {
+		log.Debugf("Watching %s for config changes", strings.Join(*watched, ", "))
+	}
+}
+
+// relevant Test whether a change to path affects the config
+//
+// This is the config file, any file it includes and any file added to or
+// removed from the `conf.d` directory.
+func (m *Manager) relevant(filename, path string) bool {
+	var fragments string = filepath.Join(filepath.Dir(filename), FragmentDirectory)
+	if path == filename || path == fragments || contains(path, m.Current().sources) {
+		return true
+	}
+	var ext string = filepath.Ext(path)
+	return filepath.Dir(path) == fragments && (ext == ".yaml" || ext == ".yml")
+}
+
+// Subscribe Get a channel which receives a value each time the configuration
+// is reloaded
+//
+// The channel is buffered so reloads which happen whilst the subscriber
+// is busy are collapsed into a single notification. Use Current to get the
+// new configuration.
+func (m *Manager) Subscribe() <-chan bool {
+	m.Lock()
+	defer m.Unlock()
+	var ch chan bool = make(chan bool, 1)
+	m.subscribers = append(m.subscribers, ch)
+	return ch
+}
+
+// Failures Get a channel which receives the error each time the config file
+// changes but cannot be loaded
+func (m *Manager) Failures() <-chan error {
+	m.Lock()
+	defer m.Unlock()
+	var ch chan error = make(chan error, 1)
+	m.failures = append(m.failures, ch)
+	return ch
+}
+
+func (m *Manager) publish() {
+	m.Lock()
+	defer m.Unlock()
+	for _, ch := range m.subscribers {
+		select {
+		case ch <- true:
+		default:
+		}
+	}
+}
+
+func (m *Manager) fail(err error) {
+	m.Lock()
+	defer m.Unlock()
+	for _, ch := range m.failures {
+		select {
+		case ch <- err:
+		default:
 		}
 	}
 }
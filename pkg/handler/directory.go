@@ -0,0 +1,141 @@
+package handler
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	c "github.com/mproffitt/importmanager/pkg/config"
+	m "github.com/mproffitt/importmanager/pkg/mime"
+	"github.com/rjeczalik/notify"
+	log "github.com/sirupsen/logrus"
+)
+
+// Directory The mime type given to directories handled as a whole
+const Directory string = "inode/directory"
+
+// recordTree Record an event for every file below a directory
+//
+// Used for directories in `recurse` mode. Files which also raise their own
+// events are merged with these so each is only handled once.
+func (s *scheduler) recordTree(dir string, ev notify.Event, filter pathFilter) {
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, e error) error {
+		if e != nil {
+			return nil
+		}
+		if excluded, _ := filter.excluded(path, d.IsDir()); excluded {
+			if d.IsDir() && path != dir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Type().IsRegular() {
+			s.record(path, ev)
+		}
+		return nil
+	})
+}
+
+// directoryUnit Find the directory a path belongs to when directories are
+// handled as a whole
+//
+// Arguments:
+//
+// - watched *config.Path The watched path
+// - path    string       The path the event was raised for
+//
+// Return:
+//
+// - string The top level directory below the watched path containing path
+// - bool   True if path belongs to a directory handled as a unit
+func directoryUnit(watched *c.Path, path string) (string, bool) {
+	if watched.Directories != c.DirectoryWhole && watched.Directories != c.DirectoryDominant {
+		return "", false
+	}
+
+	rel, err := filepath.Rel(watched.Path, path)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return "", false
+	}
+
+	var top string = filepath.Join(watched.Path, strings.Split(rel, string(filepath.Separator))[0])
+	if fi, err := os.Stat(top); err != nil || !fi.IsDir() {
+		return "", false
+	}
+	return top, true
+}
+
+// resolveDirectory Get the mime details to handle a directory with
+// according to the `directories` mode of the watched path
+//
+// Return:
+//
+// - *mime.Details The details to handle the directory as or nil to ignore it
+func resolveDirectory(path string, watched c.Path) (details *m.Details) {
+	switch watched.Directories {
+	case c.DirectoryWhole:
+		details = directoryDetails()
+	case c.DirectoryDominant:
+		if details = dominantType(path); details == nil {
+			log.Errorf("Unable to find a dominant type for directory %s", path)
+			return
+		}
+		log.Infof("Dominant type of directory %s is %s", path, details.Type)
+	default:
+		log.Debugf("Ignoring directory %s", path)
+	}
+	return
+}
+
+func directoryDetails() *m.Details {
+	if d := m.Catagories.FindBestMatchFor(Directory); d != nil {
+		return d
+	}
+	return &m.Details{
+		Catagory: "inode",
+		Type:     Directory,
+		SubClass: make([]string, 0),
+	}
+}
+
+// dominantType Find the most common mime type of the files in a directory
+//
+// Ties are broken by the total size of the files of each type.
+func dominantType(dir string) (details *m.Details) {
+	type tally struct {
+		details *m.Details
+		count   int
+		size    int64
+	}
+	var types map[string]*tally = make(map[string]*tally)
+
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, e error) error {
+		if e != nil || !d.Type().IsRegular() {
+			return nil
+		}
+		var dt *m.Details = m.Catagories.FindBestMatchFor(path)
+		if dt == nil {
+			return nil
+		}
+		if _, ok := types[dt.Type]; !ok {
+			types[dt.Type] = &tally{details: dt}
+		}
+		types[dt.Type].count++
+		if fi, err := d.Info(); err == nil {
+			types[dt.Type].size += fi.Size()
+		}
+		return nil
+	})
+
+	var best *tally
+	for _, t := range types {
+		if best == nil || t.count > best.count || (t.count == best.count && t.size > best.size) {
+			best = t
+		}
+	}
+	if best != nil {
+		details = best.details
+	}
+	return
+}
@@ -0,0 +1,176 @@
+package handler
+
+import (
+	"path/filepath"
+	re "regexp"
+	"strings"
+
+	c "github.com/mproffitt/importmanager/pkg/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// ignoreRule A single compiled pattern from a path's `ignore` list
+type ignoreRule struct {
+	pattern *re.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// pathFilter decides which files below a watched path should be handled
+type pathFilter struct {
+	root          string
+	recursive     bool
+	maxDepth      int
+	includeHidden bool
+	rules         []ignoreRule
+}
+
+func newPathFilter(path *c.Path) (f pathFilter) {
+	f = pathFilter{
+		root:          path.Path,
+		recursive:     path.Recursive,
+		maxDepth:      path.MaxDepth,
+		includeHidden: path.IncludeHidden == nil || *path.IncludeHidden,
+		rules:         make([]ignoreRule, 0),
+	}
+
+	// Files inside a dropped directory are handled individually
+	if path.Directories == c.DirectoryRecurse {
+		f.recursive = true
+	}
+
+	for _, pattern := range path.Ignore {
+		if rule, ok := compileIgnore(pattern); ok {
+			f.rules = append(f.rules, rule)
+		}
+	}
+	return
+}
+
+// excluded Test if a path should not be handled
+//
+// Arguments:
+//
+// - path  string The absolute path of the file
+// - isDir bool   True if the path is a directory
+//
+// Return:
+//
+// - bool   True if the path is excluded
+// - string Why the path was excluded
+func (f pathFilter) excluded(path string, isDir bool) (bool, string) {
+	rel, err := filepath.Rel(f.root, path)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return true, "outside of watched path"
+	}
+
+	var parts []string = strings.Split(rel, string(filepath.Separator))
+	if !f.recursive && len(parts) > 1 {
+		return true, "path is not recursive"
+	}
+	if f.maxDepth > 0 && len(parts) > f.maxDepth {
+		return true, "deeper than maxDepth"
+	}
+
+	for i, part := range parts {
+		if !f.includeHidden && strings.HasPrefix(part, ".") {
+			return true, "hidden"
+		}
+
+		// As with gitignore, a file cannot be re-included if its
+		// parent directory is excluded
+		var dir bool = i < len(parts)-1 || isDir
+		if f.ignored(strings.Join(parts[:i+1], "/"), dir) {
+			return true, "matches ignore pattern"
+		}
+	}
+	return false, ""
+}
+
+// ignored applies the rules in order. The last matching rule wins
+func (f pathFilter) ignored(rel string, isDir bool) (ignored bool) {
+	for _, rule := range f.rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		if rule.pattern.MatchString(rel) {
+			ignored = !rule.negate
+		}
+	}
+	return
+}
+
+// compileIgnore Convert a gitignore style pattern to a regular expression
+//
+// - Blank lines and lines starting with `#` are skipped
+// - `!` negates the pattern, re-including anything it matches
+// - A trailing `/` only matches directories
+// - Patterns containing `/` are relative to the watched path
+// - Patterns without `/` match at any depth
+// - `*` and `?` do not match `/`, `**` matches any number of directories
+func compileIgnore(pattern string) (rule ignoreRule, ok bool) {
+	pattern = strings.TrimSpace(pattern)
+	if pattern == "" || strings.HasPrefix(pattern, "#") {
+		return
+	}
+
+	if strings.HasPrefix(pattern, "!") {
+		rule.negate = true
+		pattern = pattern[1:]
+	}
+	if strings.HasSuffix(pattern, "/") {
+		rule.dirOnly = true
+		pattern = strings.TrimRight(pattern, "/")
+	}
+
+	var anchored bool = strings.Contains(pattern, "/")
+	pattern = strings.TrimPrefix(pattern, "/")
+
+	var expr strings.Builder
+	expr.WriteString("^")
+	if !anchored {
+		expr.WriteString("(?:.*/)?")
+	}
+	for i := 0; i < len(pattern); i++ {
+		switch {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			expr.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "/**"):
+			expr.WriteString("(?:/.*)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			expr.WriteString(".*")
+			i++
+		case pattern[i] == '*':
+			expr.WriteString("[^/]*")
+		case pattern[i] == '?':
+			expr.WriteString("[^/]")
+		case pattern[i] == '[':
+			if j := strings.IndexByte(pattern[i:], ']'); j > 0 {
+				var class string = pattern[i+1 : i+j]
+				if strings.HasPrefix(class, "!") {
+					class = "^" + class[1:]
+				}
+				expr.WriteString("[" + class + "]")
+				i += j
+				continue
+			}
+			expr.WriteString(re.QuoteMeta("["))
+		case pattern[i] == '\\' && i+1 < len(pattern):
+			i++
+			expr.WriteString(re.QuoteMeta(string(pattern[i])))
+		default:
+			expr.WriteString(re.QuoteMeta(string(pattern[i])))
+		}
+	}
+	expr.WriteString("$")
+
+	var err error
+	if rule.pattern, err = re.Compile(expr.String()); err != nil {
+		log.Errorf("Invalid ignore pattern '%s' - %s", pattern, err.Error())
+		return
+	}
+	ok = true
+	return
+}
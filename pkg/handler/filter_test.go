@@ -0,0 +1,44 @@
+package handler
+
+import (
+	"testing"
+
+	c "github.com/mproffitt/importmanager/pkg/config"
+)
+
+func TestPathFilterExcluded(t *testing.T) {
+	var (
+		include bool = true
+		exclude bool = false
+	)
+	var tests = []struct {
+		name     string
+		path     c.Path
+		file     string
+		isDir    bool
+		excluded bool
+	}{
+		{name: "hidden files are handled by default", path: c.Path{}, file: ".bashrc"},
+		{name: "hidden files can be included", path: c.Path{IncludeHidden: &include}, file: ".bashrc"},
+		{name: "hidden files can be skipped", path: c.Path{IncludeHidden: &exclude}, file: ".bashrc", excluded: true},
+		{name: "files in hidden directories can be skipped", path: c.Path{Recursive: true, IncludeHidden: &exclude}, file: ".git/config", excluded: true},
+		{name: "sub-directories need recursive", path: c.Path{}, file: "a/b.txt", excluded: true},
+		{name: "depth is limited", path: c.Path{Recursive: true, MaxDepth: 2}, file: "a/b/c.txt", excluded: true},
+		{name: "within the depth limit", path: c.Path{Recursive: true, MaxDepth: 2}, file: "a/b.txt"},
+		{name: "ignored at any depth", path: c.Path{Recursive: true, Ignore: []string{"*.swp"}}, file: "a/b.swp", excluded: true},
+		{name: "re-included", path: c.Path{Ignore: []string{"*.swp", "!keep.swp"}}, file: "keep.swp"},
+		{name: "directory patterns skip files", path: c.Path{Ignore: []string{"build/"}}, file: "build"},
+		{name: "directory patterns match directories", path: c.Path{Ignore: []string{"build/"}}, file: "build", isDir: true, excluded: true},
+		{name: "anchored patterns", path: c.Path{Recursive: true, Ignore: []string{"/tmp/*"}}, file: "a/tmp/b.txt"},
+		{name: "double star", path: c.Path{Recursive: true, Ignore: []string{"**/cache/**"}}, file: "a/cache/b/c.txt", excluded: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.path.Path = "/watched"
+			var f pathFilter = newPathFilter(&tt.path)
+			if excluded, reason := f.excluded("/watched/"+tt.file, tt.isDir); excluded != tt.excluded {
+				t.Errorf("expected excluded %t for %s, got %t (%s)", tt.excluded, tt.file, excluded, reason)
+			}
+		})
+	}
+}
@@ -2,12 +2,14 @@ package handler
 
 import (
 	"fmt"
-	"io/fs"
 	"os"
-	"sync"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"time"
 
 	c "github.com/mproffitt/importmanager/pkg/config"
+	"github.com/mproffitt/importmanager/pkg/journal"
 	m "github.com/mproffitt/importmanager/pkg/mime"
 	p "github.com/mproffitt/importmanager/pkg/processing"
 	"github.com/rjeczalik/notify"
@@ -16,20 +18,17 @@ import (
 
 const Partial string = "application/x-partial-download"
 
-func contains(what string, where []string) bool {
-	for _, p := range where {
-		if what == p {
-			return true
-		}
-	}
-	return false
-}
+// The reasons recorded for files skipped by type
+const (
+	unknownType string = "unknown or partial type"
+	noProcessor string = "no processor defined"
+)
 
 // Setup Sets up watches for each path in config
 //
 // Arguments:
 //
-// - config        *config.Config  The full configuration object
+// - manager       *config.Manager The manager holding the current configuration
 // - stop          chan bool        Write into this channel to instruct the watchers to shut down
 // - finished      chan bool        Read from this channel to know when all watchers have completed
 // - notifications chan string      A channel to write notifications back into
@@ -37,97 +36,141 @@ func contains(what string, where []string) bool {
 // Return:
 //
 // - void
-func Setup(config *c.Config, stop, finished chan bool, notifications chan string) {
-	channels := make(map[string]watch)
-	for {
-		var configpaths []string = make([]string, 0)
-		for i, p := range config.Paths {
-			configpaths = append(configpaths, p.Path)
-			if _, ok := channels[p.Path]; !ok {
-				log.Infof("Adding channel '%s'", p.Path)
-				channels[p.Path] = watch{
-					stop:     make(chan bool, 1),
-					complete: make(chan bool, 1),
-					events:   make(chan notify.EventInfo),
-				}
-				go watchLocation(&config.Paths[i], channels[p.Path], config, notifications)
-			}
-		}
+func Setup(manager *c.Manager, stop, finished chan bool, notifications chan string) {
+	var (
+		channels map[string]watch = make(map[string]watch)
+		changes  <-chan bool      = manager.Subscribe()
+		failures <-chan error     = manager.Failures()
+		reporter chan bool        = make(chan bool)
+	)
+	go engine.reporter(reporter)
 
-		for k := range channels {
-			if !contains(k, configpaths) {
-				log.Infof("Deleting channel '%s'", k)
+	reconcile(manager.Current(), channels, notifications)
+	for {
+		select {
+		case <-stop:
+			for k := range channels {
 				channels[k].stop <- true
 				<-channels[k].complete
-				delete(channels, k)
 			}
+			engine.stop()
+			close(reporter)
+			finished <- true
+			return
+		case <-changes:
+			log.Info("Configuration changed. Updating watchers")
+			reconcile(manager.Current(), channels, notifications)
+		case err := <-failures:
+			notifications <- fmt.Sprintf("Configuration was not reloaded. %s", err.Error())
 		}
-		var breakLoop bool = false
-		select {
-		case <-stop:
-			breakLoop = true
-			break
-		default:
-			<-time.After(1 * time.Second)
-		}
+	}
+}
+
+// reconcile Bring the watchers in line with a new configuration
+//
+// Watchers for new paths are started and those for paths no longer
+// configured are stopped. Watchers whose path, processors or global
+// settings changed are restarted. All others keep running untouched.
+func reconcile(config *c.Config, channels map[string]watch, notifications chan string) {
+	engine.configure(config)
+	tracker.configure(config)
 
-		if breakLoop {
-			break
+	var configured map[string]bool = make(map[string]bool)
+	for _, p := range config.Paths {
+		configured[p.Path] = true
+		var def definition = definition{
+			path:  p,
+			delay: config.DelayInSeconds * time.Second,
+			czb:   config.CleanupZeroByte,
+		}
+		if w, ok := channels[p.Path]; ok {
+			if reflect.DeepEqual(w.definition, def) {
+				continue
+			}
+			log.Infof("Restarting channel '%s'", p.Path)
+			w.stop <- true
+			<-w.complete
+		} else {
+			log.Infof("Adding channel '%s'", p.Path)
 		}
+		channels[p.Path] = watch{
+			definition: def,
+			stop:       make(chan bool, 1),
+			complete:   make(chan bool, 1),
+			events:     make(chan notify.EventInfo),
+		}
+		go watchLocation(channels[p.Path], notifications)
 	}
 
 	for k := range channels {
-		channels[k].stop <- true
-		<-channels[k].complete
+		if !configured[k] {
+			log.Infof("Deleting channel '%s'", k)
+			channels[k].stop <- true
+			<-channels[k].complete
+			delete(channels, k)
+		}
 	}
-	finished <- true
-
 }
 
 // Handle Finds the processor for a given filepath and triggers it
 //
 // Arguments:
 //
-// - path:       string             The path to a file to process
-// - details:    mime.Details       Mime information about the given file
-// - processors: []config.Processor A list of processors for the files base path
-// - czb:        bool               Clear zero byte files If true will automatically delete empty files
+// - path:    string       The path to a file to process
+// - details: mime.Details Mime information about the given file
+// - watched: config.Path  The watched path the file was found in
+// - czb:     bool         Clear zero byte files If true will automatically delete empty files
 //
 // Return:
 //
 // - error The past known error
-func Handle(path string, details m.Details, processors []c.Processor, czb bool) (err error) {
+func Handle(path string, details m.Details, watched c.Path, czb bool) (err error) {
 	log.Infof("Handling path %s", path)
-	var (
-		dryrun bool = details.DryRun
-		fi     fs.FileInfo
-	)
-	if !dryrun {
-		if fi, err = os.Stat(path); err == nil {
-			if fi.Size() == 0 && czb {
-				log.Infof("Deleting path '%s'. File is empty", path)
-				os.Remove(path)
-				return
-			}
-		}
+	if !details.DryRun && removeEmpty(path, czb) {
+		return
 	}
 
-	var processor *c.Processor
+	var processor *c.Processor = FindProcessor(&details, watched.Processors)
+	if processor == nil {
+		log.Errorf("No processor defined for type '%s | %s | %s'", details.Type, details.SubClass, details.Catagory)
+		if details.DryRun {
+			c.DeleteDryRunPath(path)
+		}
+		return
+	}
+	// The processor belongs to the configuration snapshot. Each job works
+	// on its own copy
+	var q c.Processor = processor.Clone()
+	err = process(path, &details, watched, &q)
+	return
+}
 
+// FindProcessor Find the processor for a mime type
+//
+// An exact match on the type is preferred, followed by a parent type and
+// finally the category or the `*` wildcard.
+//
+// Arguments:
+//
+// - details    *mime.Details      Mime information about the file
+// - processors []config.Processor The processors of the watched path
+//
+// Return:
+//
+// - *config.Processor The matching processor or nil if none match
+func FindProcessor(details *m.Details, processors []c.Processor) (processor *c.Processor) {
 	// try find an exact processor for this mimetype
 	for i, p := range processors {
 		if p.Type == details.Type && !p.Negated {
-			processor = &processors[i]
-			break
+			return &processors[i]
 		}
 	}
 
 	// If we don't have an exact match and this is a subclass, try that
-	if processor == nil && details.IsSubClass() {
+	if details.IsSubClass() {
 		for i, p := range processors {
 			if details.IsSubClassOf(p.Type) && !p.Negated {
-				processor = &processors[i]
-				break
+				return &processors[i]
 			}
 		}
 	}
@@ -135,73 +178,114 @@ func Handle(path string, details m.Details, processors []c.Processor, czb bool)
 	// If we still don't have a processor fall back to catagory level
 	// This will also allow wildcard for processor.Type so anything not
 	// handled can be handled by a fallback.
-	if processor == nil {
-		for i, p := range processors {
-			if (p.Type == details.Catagory || p.Type == "*") && !p.Negated {
-				processor = &processors[i]
-				break
-			}
-		}
-		if processor == nil {
-			log.Errorf("No processor defined for type '%s | %s | %s'", details.Type, details.SubClass, details.Catagory)
-			if details.DryRun {
-				c.DeleteDryRunPath(path)
-			}
-			return
+	for i, p := range processors {
+		if (p.Type == details.Catagory || p.Type == "*") && !p.Negated {
+			return &processors[i]
 		}
 	}
+	return
+}
 
+// removeEmpty Delete a zero byte file when `cleanupZeroByte` is set
+func removeEmpty(path string, czb bool) bool {
+	if empty(path, czb) {
+		log.Infof("Deleting path '%s'. File is empty", path)
+		os.Remove(path)
+		return true
+	}
+	return false
+}
+
+// empty Test whether a file is zero bytes and `cleanupZeroByte` is set
+func empty(path string, czb bool) bool {
+	fi, err := os.Stat(path)
+	return err == nil && !fi.IsDir() && fi.Size() == 0 && czb
+}
+
+func process(path string, details *m.Details, watched c.Path, processor *c.Processor) (err error) {
 	log.Infof("Found processor '%s' for path %s", processor.String(), path)
-	if err := p.Process(path, &details, processor); err != nil {
+	if err = p.Process(path, watched.Path, details, processor); p.IsHandled(err) {
+		log.Infof("Path %s was handled - %s", path, err.Error())
+		err = nil
+	}
+	if err != nil {
 		log.Errorf("Unable to process path %s - %s", path, err.Error())
 	}
 	log.Infof("Completed parsing for %s", path)
 	return
 }
 
-func watchLocation(path *c.Path, channel watch, config *c.Config, notifications chan string) {
+func watchLocation(channel watch, notifications chan string) {
 	var (
-		wg         sync.WaitGroup
-		jobs       chan job  = make(chan job, config.BufferSize)
-		stopEvents chan bool = make(chan bool)
-		events     lockable  = lockable{
-			paths: make(map[string]event),
-		}
+		path       *c.Path    = &channel.definition.path
+		stopEvents chan bool  = make(chan bool)
+		stopQueue  chan bool  = make(chan bool)
+		events     *scheduler = newScheduler(channel.definition.delay)
 	)
 
-	for i := 0; i < config.BufferSize; i++ {
-		wg.Add(1)
-		log.Debugf("Starting %s worker %d", path.Path, i)
-		go pathWorker(jobs, &wg)
+	var complete = func(handled int) {
+		if path.Mode == c.ModeObserve {
+			notifications <- fmt.Sprintf("Observing path %s. %d files would have been handled. See `importmanager summary`.", path.Path, handled)
+			return
+		}
+		notifications <- fmt.Sprintf("Processing for path %s completed. %d files handled.", path.Path, handled)
 	}
 
-	ev := notify.All | notify.InCloseWrite | notify.InModify
-	if err := notify.Watch(path.Path, channel.events, ev); err != nil {
-		log.Fatalf("Failed to set up watch for path %s - %s", path.Path, err.Error())
-		return
+	var (
+		filter pathFilter = newPathFilter(path)
+		target string     = path.Path
+	)
+	// Directories handled as units need events from inside them to
+	// know when they are complete
+	if path.Recursive || (path.Directories != "" && path.Directories != c.DirectoryIgnore) {
+		target = filepath.Join(path.Path, "...")
 	}
 
-	log.Info("Starting listening to: ", path.Path)
+	// Receives a value when the watched path itself is removed
+	var lost chan bool = make(chan bool, 1)
+
 	go func(done chan bool) {
 		defer close(done)
 		for {
 			select {
 			case ei := <-channel.events:
+				var p string = ei.Path()
+
+				// Events inside a directory handled as a unit are
+				// recorded against the directory itself
+				if unit, ok := directoryUnit(path, p); ok && unit != p {
+					log.Debugf("Recording event for %s against directory %s", p, unit)
+					events.record(unit, ei.Event())
+					continue
+				}
+
 				switch ei.Event() {
 				case notify.Remove:
-					events.RLock()
-					delete(events.paths, ei.Path())
-					events.RUnlock()
+					if p == path.Path {
+						select {
+						case lost <- true:
+						default:
+						}
+						continue
+					}
+					events.remove(p)
 				default:
-					if _, err := os.Stat(ei.Path()); err != nil {
+					fi, err := os.Stat(p)
+					if err != nil {
+						continue
+					}
+					if excluded, reason := filter.excluded(p, fi.IsDir()); excluded {
+						log.Debugf("Skipping %s - %s", p, reason)
 						continue
 					}
-					events.RLock()
-					events.paths[ei.Path()] = event{
-						event: ei.Event(),
-						time:  time.Now(),
+					if fi.IsDir() && path.Directories == c.DirectoryRecurse {
+						events.recordTree(p, ei.Event(), filter)
+						continue
+					}
+					if fi.IsDir() && path.Directories != c.DirectoryWhole && path.Directories != c.DirectoryDominant {
+						continue
 					}
-					events.RUnlock()
+					events.record(p, ei.Event())
 				}
 			case <-done:
 				return
@@ -209,85 +293,137 @@ func watchLocation(path *c.Path, channel watch, config *c.Config, notifications
 		}
 	}(stopEvents)
 
-	for {
-		select {
-		case <-channel.stop:
-			log.Infof("Shutting down listener for path %s", path.Path)
-			for i := 0; i < config.BufferSize; i++ {
-				jobs <- job{ready: false}
-			}
-			wg.Wait()
-			stopEvents <- true
+	// Paths are handed to the worker pool as soon as their quiet
+	// period expires
+	go events.run(func(p string, e event) {
+		log.Infof("Creating job for path '%s'", p)
+		var t *task = &task{
+			path:     p,
+			watched:  *path,
+			event:    e,
+			czb:      channel.definition.czb,
+			events:   events,
+			complete: complete,
+			notify: func(msg string) {
+				notifications <- msg
+			},
+		}
+		if fi, err := os.Stat(p); err == nil && !fi.IsDir() {
+			t.size = fi.Size()
+		}
+		journal.Queue(p, path.Path, e.time)
+		engine.submit(t)
+	}, stopQueue)
 
-			close(jobs)
-			log.Debug("All goroutines closed")
-			notify.Stop(channel.events)
-			channel.complete <- true
-			return
-		default:
-			events.Lock()
-			eventLen, paths := eventPaths(events.paths)
-			events.Unlock()
-			var available int = cap(jobs) - len(jobs)
-
-			// If theres nothing to do or no capacity, sleep
-			if eventLen == 0 || available == 0 {
-				<-time.After(10 * time.Millisecond)
+	// Jobs left unfinished by the last run are queued again once the
+	// path can be watched
+	var resume = func() {
+		for _, r := range journal.Pending(path.Path) {
+			if _, err := os.Lstat(r.Path); err != nil {
+				journal.Cancel(r.Path, "source no longer exists")
 				continue
 			}
+			log.Infof("Resuming %s job for %s", r.State, r.Path)
+			events.requeue(r.Path, event{event: notify.Create, time: r.Event})
+		}
+	}
 
-			if available < eventLen {
-				paths = paths[:available]
-			}
+	supervise(path, target, channel, lost, notifications, resume)
+	log.Infof("Shutting down listener for path %s", path.Path)
+	close(stopQueue)
+	engine.cancel(path.Path)
+	engine.wait(path.Path)
+	stopEvents <- true
 
-			for _, p := range paths {
-				events.Lock()
-				eventTime := events.paths[p].time
-				events.Unlock()
-				if eventTime.Before(time.Now().Add(-(config.DelayInSeconds * time.Second))) {
-					events.RLock()
-					delete(events.paths, p)
-					events.RUnlock()
-
-					log.Infof("Creating job for path '%s'", p)
-					jobs <- job{
-						path:       p,
-						processors: path.Processors,
-						czb:        config.CleanupZeroByte,
-						ready:      true,
-					}
-				}
-			}
+	log.Debug("All jobs completed")
+	engine.setState(path.Path, "")
+	channel.complete <- true
+}
 
-			if len(events.paths) == 0 && available == config.BufferSize {
-				notifications <- fmt.Sprintf("Processing for path %s completed.", path.Path)
-			}
+// run Execute a task taken from the worker pool
+//
+// A task first waits for the path to be ready and resolves its processor.
+// It is then returned to the pool so the handler and device limits can be
+// applied before it is processed.
+func (t *task) run() outcome {
+	if t.processor != nil {
+		if t.observing() {
+			return t.observe()
 		}
+		id, route, err := tracker.check(t.path, t.watched.Path, t.handler, t.processor.Path)
+		if err != nil {
+			return t.loop(route, err)
+		}
+		t.attempt++
+		journal.Start(t.path, t.details, t.processor)
+		if err = process(t.path, t.details, t.watched, t.processor); err != nil {
+			return t.failed(err)
+		}
+		tracker.record(id, route)
+		journal.Finish(t.path, nil)
+		return handled
 	}
-}
 
-func eventPaths(events map[string]event) (length int, paths []string) {
-	for p := range events {
-		paths = append(paths, p)
+	switch state, reason := checkReadiness(t.path, t.event, t.watched.Readiness); state {
+	case ignore:
+		log.Debugf("Ignoring path %s - %s", t.path, reason)
+		journal.Skip(t.path, reason)
+		return skipped
+	case notReady:
+		log.Infof("Path %s is not ready (%s). Requeueing", t.path, reason)
+		t.events.requeue(t.path, t.event)
+		return skipped
 	}
-	length = len(paths)
-	return
-}
 
-func pathWorker(jobs chan job, wg *sync.WaitGroup) {
-	defer wg.Done()
-	for {
-		var j job = <-jobs
-		if !j.ready {
-			log.Info("Shutting down worker")
-			return
+	if t.observing() && empty(t.path, t.czb) {
+		return t.observe()
+	}
+	if removeEmpty(t.path, t.czb) {
+		journal.Finish(t.path, nil)
+		return handled
+	}
+
+	if fi, err := os.Stat(t.path); err == nil && fi.IsDir() {
+		t.details = resolveDirectory(t.path, t.watched)
+		if s, err := sign(t.path); err == nil {
+			t.size = s.size
 		}
-		var details = m.Catagories.FindBestMatchFor(j.path)
-		if details != nil && details.Type != Partial {
-			log.Infof("Starting processing path %s", j.path)
-			Handle(j.path, *details, j.processors, j.czb)
-			log.Infof("Finished processing path %s", j.path)
+	} else {
+		if err == nil {
+			t.size = fi.Size()
 		}
+		if t.details = m.Catagories.FindBestMatchFor(t.path); t.details != nil && t.details.Type == Partial {
+			t.details = nil
+		}
+	}
+	if t.details == nil {
+		journal.Skip(t.path, unknownType)
+		return skipped
+	}
+
+	// Interrupted jobs are resumed with the processor they were started
+	// with, even if the configuration has changed since
+	if processor, details, ok := journal.Snapshot(t.path); ok {
+		log.Infof("Resuming interrupted job for %s with processor '%s'", t.path, processor.String())
+		t.processor, t.details = processor, details
+	} else if processor = FindProcessor(t.details, t.watched.Processors); processor != nil {
+		// Tasks run concurrently so each is given its own copy of the
+		// processor shared by the watched path
+		var q c.Processor = processor.Clone()
+		t.processor = &q
+	} else {
+		log.Errorf("No processor defined for type '%s | %s | %s'", t.details.Type, t.details.SubClass, t.details.Catagory)
+		journal.Skip(t.path, noProcessor)
+		return skipped
+	}
+	t.handler = strings.ToLower(filepath.Base(t.processor.Handler))
+	t.device = destinationDevice(t.processor)
+	return resolved
+}
 
+// done Tell the scheduler the task has finished
+func (t *task) done(handled bool) {
+	if n := t.events.finished(handled); n > 0 && t.complete != nil {
+		t.complete(n)
 	}
 }
@@ -0,0 +1,159 @@
+package handler
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	c "github.com/mproffitt/importmanager/pkg/config"
+	"github.com/rjeczalik/notify"
+	log "github.com/sirupsen/logrus"
+)
+
+// The health of a watched path
+const (
+	// PathWaiting The path does not exist yet or could not be watched
+	PathWaiting = "waiting"
+
+	// PathActive The path is being watched
+	PathActive = "active"
+
+	// PathLost The path was being watched but has disappeared
+	PathLost = "lost"
+)
+
+// WatchRetryInterval The first wait before a path which cannot be watched is tried again
+const WatchRetryInterval = 1 * time.Second
+
+// MaxWatchRetryInterval The longest wait between attempts to watch a path
+const MaxWatchRetryInterval = 60 * time.Second
+
+// HealthInterval How often a watched path is checked to still exist
+const HealthInterval = 5 * time.Second
+
+// watchEvents The events listened for on each watched path
+const watchEvents = notify.All | notify.InCloseWrite | notify.InModify
+
+// supervise Keep a watch on a path until told to stop
+//
+// A path which does not exist is tried again with backoff, or created when
+// `createIfMissing` is set. A watched path which disappears, for example
+// because the drive it is on was unmounted, is marked as lost and watched
+// again as soon as it reappears. Other paths are not affected.
+//
+// The watch is stopped before returning.
+//
+// Arguments:
+//
+// - path          *config.Path The watched path
+// - target        string       The path passed to notify.Watch
+// - channel       watch        The channels of the watcher for this path
+// - lost          chan bool    Receives a value when the root of the path is removed
+// - notifications chan string  A channel to write notifications back into
+// - started       func()       Called the first time the path is watched
+func supervise(path *c.Path, target string, channel watch, lost chan bool, notifications chan string, started func()) {
+	var (
+		delay time.Duration = WatchRetryInterval
+		state string        = PathWaiting
+	)
+	engine.setState(path.Path, state)
+
+	for {
+		root, unwatch, err := establish(path, target, channel.events)
+		if err != nil {
+			log.Warnf("Unable to watch path %s (%s). Trying again in %s", path.Path, err.Error(), delay)
+			select {
+			case <-channel.stop:
+				return
+			case <-time.After(delay):
+			}
+			if delay *= 2; delay > MaxWatchRetryInterval {
+				delay = MaxWatchRetryInterval
+			}
+			continue
+		}
+
+		if state == PathLost {
+			notifications <- fmt.Sprintf("Path %s is available again", path.Path)
+		}
+		delay, state = WatchRetryInterval, PathActive
+		engine.setState(path.Path, state)
+		log.Info("Starting listening to: ", path.Path)
+		if started != nil {
+			started()
+			started = nil
+		}
+
+		var stopped bool = !healthy(path, root, channel.stop, lost)
+		unwatch()
+		if stopped {
+			return
+		}
+
+		log.Errorf("Path %s has been lost. Waiting for it to reappear", path.Path)
+		state = PathLost
+		engine.setState(path.Path, state)
+		notifications <- fmt.Sprintf("Path %s has been lost. Waiting for it to reappear", path.Path)
+	}
+}
+
+// establish Create the path if required and start watching it
+//
+// Return:
+//
+// - os.FileInfo The root of the watched path
+// - func()      Stops the watch
+// - error       Any error finding or watching the path
+func establish(path *c.Path, target string, events chan notify.EventInfo) (root os.FileInfo, unwatch func(), err error) {
+	if root, err = os.Stat(path.Path); os.IsNotExist(err) && path.CreateIfMissing && path.Mode != c.ModeObserve {
+		log.Infof("Creating missing path %s", path.Path)
+		if err = os.MkdirAll(path.Path, 0750); err != nil {
+			return
+		}
+		root, err = os.Stat(path.Path)
+	}
+	if err != nil {
+		return
+	}
+	if !root.IsDir() {
+		err = fmt.Errorf("not a directory")
+		return
+	}
+
+	if backend(path) == c.WatcherPoll {
+		log.Infof("Polling %s every %s", path.Path, path.PollInterval*time.Second)
+		var w *poller = newPoller(path.Path, target != path.Path, path.PollInterval*time.Second, events)
+		unwatch = w.close
+		return
+	}
+	if err = notify.Watch(target, events, watchEvents); err == nil {
+		unwatch = func() {
+			notify.Stop(events)
+		}
+	}
+	return
+}
+
+// healthy Wait until the path is lost or the watcher is stopped
+//
+// The path is lost when it no longer exists or is replaced by a different
+// directory, as happens when a drive mounted on it is unmounted.
+//
+// Return:
+//
+// - bool False if the watcher was stopped
+func healthy(path *c.Path, root os.FileInfo, stop, lost chan bool) bool {
+	var ticker *time.Ticker = time.NewTicker(HealthInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return false
+		case <-ticker.C:
+		case <-lost:
+		}
+		if fi, err := os.Stat(path.Path); err != nil || !os.SameFile(fi, root) {
+			return true
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package handler
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	c "github.com/mproffitt/importmanager/pkg/config"
+	"github.com/mproffitt/importmanager/pkg/journal"
+	log "github.com/sirupsen/logrus"
+)
+
+var tracker *routes = &routes{
+	hops: make(map[string][]hop),
+}
+
+// configure Apply the loop detection limits from the configuration
+func (r *routes) configure(config *c.Config) {
+	r.Lock()
+	defer r.Unlock()
+	r.maxHops = config.LoopDetection.MaxHops
+	r.window = config.LoopDetection.Window * time.Second
+}
+
+// check Test whether content is looping before it is handled
+//
+// Arguments:
+//
+// - path        string The file about to be handled
+// - watched     string The watched path the file was found in
+// - handler     string The handler which will be run
+// - destination string The path the processor writes to
+//
+// Return:
+//
+// - string The identity of the content, empty if it could not be identified
+// - []hop  The route the content has taken, ending with this visit
+// - error  Describes the loop if the content should not be handled
+func (r *routes) check(path, watched, handler, destination string) (id string, route []hop, err error) {
+	var e error
+	if id, e = identify(path); e != nil {
+		// Content which cannot be identified is not tracked
+		log.Debugf("Unable to identify %s for loop detection - %s", path, e.Error())
+		return "", nil, nil
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	r.expire(time.Now())
+
+	route = append(append(make([]hop, 0), r.hops[id]...), hop{
+		watched:     watched,
+		path:        path,
+		handler:     handler,
+		destination: destination,
+		time:        time.Now(),
+	})
+	// Content handled in this path before is only a loop if it has
+	// been handled somewhere else since
+	var left bool
+	for i := len(route) - 2; i >= 0; i-- {
+		if route[i].watched != watched {
+			left = true
+			continue
+		}
+		if left {
+			err = fmt.Errorf("content returned to %s which it already passed through", watched)
+			return
+		}
+	}
+	if r.maxHops > 0 && distinct(route) > r.maxHops {
+		err = fmt.Errorf("content passed through more than %d processors within %s", r.maxHops, r.window)
+	}
+	return
+}
+
+// record Add a successfully handled visit to the route of the content
+//
+// Only visits which succeeded are recorded so a file which failed and is
+// put back into the same path is not mistaken for a loop.
+func (r *routes) record(id string, route []hop) {
+	if id == "" || len(route) == 0 {
+		return
+	}
+	r.Lock()
+	defer r.Unlock()
+	r.hops[id] = append(r.hops[id], route[len(route)-1])
+}
+
+// identify Get the identity of the content of a file or directory
+//
+// Files are identified by their size and modification time and directories
+// by their inode. Neither needs the content to be read.
+func identify(path string) (id string, err error) {
+	var fi os.FileInfo
+	if fi, err = os.Stat(path); err != nil {
+		return
+	}
+	if !fi.IsDir() {
+		return fmt.Sprintf("file:%x:%x", fi.Size(), fi.ModTime().UnixNano()), nil
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		err = fmt.Errorf("no inode available")
+		return
+	}
+	return fmt.Sprintf("dir:%x:%x", uint64(st.Dev), uint64(st.Ino)), nil
+}
+
+// distinct Count the different routes in a list of hops
+//
+// Content handled the same way more than once, such as a file downloaded
+// again, only counts once.
+func distinct(route []hop) int {
+	var seen map[hop]bool = make(map[hop]bool)
+	for _, h := range route {
+		seen[hop{watched: h.watched, handler: h.handler, destination: h.destination}] = true
+	}
+	return len(seen)
+}
+
+// expire Forget hops older than the window. The routes must be locked
+func (r *routes) expire(now time.Time) {
+	for id, route := range r.hops {
+		var i int
+		for i < len(route) && now.Sub(route[i].time) > r.window {
+			i++
+		}
+		if i == len(route) {
+			delete(r.hops, id)
+		} else if i > 0 {
+			r.hops[id] = route[i:]
+		}
+	}
+}
+
+// describe Format a route for logs and notifications
+func describe(route []hop) string {
+	var parts []string = make([]string, 0, len(route))
+	for _, h := range route {
+		parts = append(parts, fmt.Sprintf("%s (%s)", h.watched, h.handler))
+	}
+	return strings.Join(parts, " → ")
+}
+
+// loop Stop a task whose content is looping between watched paths
+func (t *task) loop(route []hop, cause error) outcome {
+	var message string = fmt.Sprintf("Loop detected for %s: %s. Route: %s", filepath.Base(t.path), cause.Error(), describe(route))
+	log.Error(message)
+
+	var err error = fmt.Errorf("%s. Route: %s", cause.Error(), describe(route))
+	journal.Finish(t.path, err)
+	if final, e := quarantine(t, engine.quarantineDirectory(), err); e != nil {
+		log.Errorf("Unable to quarantine %s - %s", t.path, e.Error())
+	} else {
+		log.Warnf("Quarantined %s as %s", t.path, final)
+	}
+	t.notify(message)
+	return handled
+}
@@ -0,0 +1,104 @@
+package handler
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// visit Check and record a file being handled by the given route
+func visit(t *testing.T, r *routes, path, watched, handler, destination string) error {
+	t.Helper()
+	id, route, err := r.check(path, watched, handler, destination)
+	if err == nil {
+		r.record(id, route)
+	}
+	return err
+}
+
+func TestLoopDetection(t *testing.T) {
+	var (
+		dir       string    = t.TempDir()
+		file      string    = filepath.Join(dir, "photo.jpg")
+		downloads string    = filepath.Join(dir, "Downloads")
+		pictures  string    = filepath.Join(dir, "Pictures")
+		modtime   time.Time = time.Date(2023, 9, 12, 23, 34, 0, 0, time.UTC)
+	)
+	if err := os.WriteFile(file, []byte("content"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(file, modtime, modtime); err != nil {
+		t.Fatal(err)
+	}
+
+	type step struct {
+		watched     string
+		destination string
+	}
+	var tests = []struct {
+		name  string
+		steps []step
+		loop  bool
+	}{
+		{
+			name:  "the same download handled repeatedly",
+			steps: []step{{downloads, pictures}, {downloads, pictures}, {downloads, pictures}, {downloads, pictures}},
+		},
+		{
+			name:  "content passing between two watched paths",
+			steps: []step{{downloads, pictures}, {pictures, downloads}, {downloads, pictures}},
+			loop:  true,
+		},
+		{
+			name:  "content passing through too many processors",
+			steps: []step{{downloads, "/a"}, {downloads, "/b"}, {downloads, "/c"}},
+			loop:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				r    *routes = &routes{maxHops: 2, window: time.Minute, hops: make(map[string][]hop)}
+				err  error
+				last int = len(tt.steps) - 1
+			)
+			for i, s := range tt.steps {
+				if err = visit(t, r, file, s.watched, "move", s.destination); err != nil && i < last {
+					t.Fatalf("step %d was stopped early - %s", i+1, err)
+				}
+			}
+			if tt.loop != (err != nil) {
+				t.Errorf("expected loop %t, got %v", tt.loop, err)
+			}
+		})
+	}
+}
+
+func TestIdentifyDoesNotDependOnName(t *testing.T) {
+	var (
+		dir     string    = t.TempDir()
+		first   string    = filepath.Join(dir, "a.iso")
+		second  string    = filepath.Join(dir, "b.iso")
+		modtime time.Time = time.Now().Add(-time.Hour)
+	)
+	for _, path := range []string{first, second} {
+		if err := os.WriteFile(path, []byte("content"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modtime, modtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a, err := identify(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := identify(second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a != b {
+		t.Errorf("expected copies with the same size and time to match, got %s and %s", a, b)
+	}
+}
@@ -0,0 +1,88 @@
+package handler
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	c "github.com/mproffitt/importmanager/pkg/config"
+	"github.com/mproffitt/importmanager/pkg/journal"
+	p "github.com/mproffitt/importmanager/pkg/processing"
+	log "github.com/sirupsen/logrus"
+)
+
+// verbs The past participle of each built-in handler for describing actions
+var verbs = map[string]string{
+	"copy":    "copied",
+	"move":    "moved",
+	"extract": "extracted",
+	"install": "installed",
+	"delete":  "deleted",
+}
+
+// observing Test whether the path a task was found in is in observe mode
+func (t *task) observing() bool {
+	return t.watched.Mode == c.ModeObserve
+}
+
+// observe Record what a task would do without touching the file
+//
+// The destination is rendered and the plugin checked as they would be when
+// the file is handled. The result is written to the journal and sent as a
+// notification. A task without a processor is an empty file which would
+// have been deleted.
+func (t *task) observe() outcome {
+	var (
+		destination string
+		err         error
+	)
+	if t.processor != nil {
+		journal.Start(t.path, t.details, t.processor)
+		destination, err = p.Plan(t.path, t.watched.Path, t.details, t.processor)
+	}
+	journal.Observe(t.path, destination, err)
+
+	var failure string
+	if err != nil {
+		failure = err.Error()
+	}
+	var message string = Describe(t.path, t.processor, destination, failure)
+	log.Infof("Observed: %s", message)
+	t.notify("Observed: " + message)
+	return handled
+}
+
+// Describe Describe the action taken or planned for a file
+//
+// Arguments:
+//
+// - path        string            The file
+// - processor   *config.Processor The processor for the file. Nil for an empty file
+// - destination string            Where the file was or would be written
+// - failure     string            The error the processor failed with, if any
+//
+// Return:
+//
+// - string A description such as `a.jpg would be moved to /images/a.jpg`
+func Describe(path string, processor *c.Processor, destination, failure string) string {
+	var name string = filepath.Base(path)
+	if processor == nil {
+		return fmt.Sprintf("%s would be deleted as it is empty", name)
+	}
+
+	var (
+		handler string = filepath.Base(processor.Handler)
+		verb    string
+		ok      bool
+	)
+	if failure != "" {
+		return fmt.Sprintf("%s would fail with %s (%s): %s", name, handler, processor.Type, failure)
+	}
+	if verb, ok = verbs[strings.ToLower(handler)]; !ok {
+		return fmt.Sprintf("%s would be passed to plugin %s (%s) for %s", name, handler, processor.Type, destination)
+	}
+	if destination == "" {
+		return fmt.Sprintf("%s would be %s (%s)", name, verb, processor.Type)
+	}
+	return fmt.Sprintf("%s would be %s to %s (%s)", name, verb, destination, processor.Type)
+}
@@ -0,0 +1,206 @@
+package handler
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	c "github.com/mproffitt/importmanager/pkg/config"
+	"github.com/rjeczalik/notify"
+	log "github.com/sirupsen/logrus"
+)
+
+// networkFilesystems statfs magic numbers of filesystems inotify cannot
+// fully watch
+var networkFilesystems = map[int64]string{
+	0x6969:     "nfs",
+	0x517b:     "smb",
+	0xfe534d42: "smb2",
+	0xff534d42: "cifs",
+	0x65735546: "fuse",
+	0x01021997: "9p",
+	0x00c36400: "ceph",
+}
+
+// backend Decide how changes to a watched path are detected
+//
+// With `auto`, paths on a network or FUSE filesystem are polled.
+func backend(path *c.Path) string {
+	switch path.Watcher {
+	case c.WatcherInotify, c.WatcherPoll:
+		return path.Watcher
+	case c.WatcherAuto:
+	default:
+		log.Errorf("Unknown watcher '%s' for path %s. Using '%s'", path.Watcher, path.Path, c.WatcherAuto)
+	}
+
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path.Path, &st); err != nil {
+		return c.WatcherInotify
+	}
+	if name, ok := networkFilesystems[int64(st.Type)]; ok {
+		log.Infof("Path %s is on a %s filesystem. Polling for changes", path.Path, name)
+		return c.WatcherPoll
+	}
+	return c.WatcherInotify
+}
+
+// newPoller Start polling a path
+//
+// The first snapshot is taken straight away and produces no events so only
+// files which change after the watch starts are reported, as with inotify.
+//
+// Arguments:
+//
+// - root      string                 The watched path
+// - recursive bool                   If true, changes below subdirectories are reported
+// - interval  time.Duration          The time between snapshots
+// - events    chan notify.EventInfo  Where events are sent
+//
+// Return:
+//
+// - *poller The running poller. Call `close` to stop it
+func newPoller(root string, recursive bool, interval time.Duration, events chan notify.EventInfo) (w *poller) {
+	w = &poller{
+		root:      root,
+		recursive: recursive,
+		interval:  interval,
+		events:    events,
+		stop:      make(chan bool),
+		changing:  make(map[string]bool),
+	}
+	w.files, _ = w.snapshot()
+	go w.run()
+	return
+}
+
+// close Stop polling
+func (w *poller) close() {
+	close(w.stop)
+}
+
+func (w *poller) run() {
+	var ticker *time.Ticker = time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			if !w.poll() {
+				return
+			}
+		}
+	}
+}
+
+// poll Take a snapshot and send an event for each difference from the last
+//
+// New files are reported as created and changed files as written. A file
+// which was created or written is reported as closed once it is unchanged
+// in the following snapshot, so `closewrite` readiness works when polling.
+//
+// Return:
+//
+// - bool False if the poller was stopped whilst sending events
+func (w *poller) poll() bool {
+	var files, ok = w.snapshot()
+	if !ok {
+		// The root could not be read. Losing the path is handled by
+		// the health check
+		return true
+	}
+
+	for path := range w.files {
+		if _, ok := files[path]; !ok {
+			delete(w.changing, path)
+			if !w.send(notify.Remove, path) {
+				return false
+			}
+		}
+	}
+	for path, now := range files {
+		var (
+			before, seen = w.files[path]
+			ev           notify.Event
+		)
+		switch {
+		case !seen || before.inode != now.inode || before.dir != now.dir:
+			ev = notify.Create
+		case now.dir:
+			continue
+		case before.size != now.size || !before.modtime.Equal(now.modtime):
+			ev = notify.Write
+		case w.changing[path]:
+			delete(w.changing, path)
+			if !w.send(notify.InCloseWrite, path) {
+				return false
+			}
+			continue
+		default:
+			continue
+		}
+		if !now.dir {
+			w.changing[path] = true
+		}
+		if !w.send(ev, path) {
+			return false
+		}
+	}
+	w.files = files
+	return true
+}
+
+func (w *poller) send(ev notify.Event, path string) bool {
+	select {
+	case w.events <- polled{event: ev, path: path}:
+		return true
+	case <-w.stop:
+		return false
+	}
+}
+
+// snapshot Record the size, modification time and inode of each file
+//
+// Return:
+//
+// - map[string]fileState The files found, by path
+// - bool                  False if the root could not be read
+func (w *poller) snapshot() (files map[string]fileState, ok bool) {
+	files = make(map[string]fileState)
+	var err error = filepath.Walk(w.root, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if path == w.root {
+				return err
+			}
+			// Files removed during the walk are reported by the next snapshot
+			return nil
+		}
+		if path == w.root {
+			return nil
+		}
+		var f fileState = fileState{size: fi.Size(), modtime: fi.ModTime(), dir: fi.IsDir()}
+		if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+			f.inode = uint64(st.Ino)
+		}
+		files[path] = f
+		if fi.IsDir() && !w.recursive {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	return files, err == nil
+}
+
+func (e polled) Event() notify.Event {
+	return e.event
+}
+
+func (e polled) Path() string {
+	return e.path
+}
+
+func (e polled) Sys() interface{} {
+	return nil
+}
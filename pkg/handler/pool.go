@@ -0,0 +1,415 @@
+package handler
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	c "github.com/mproffitt/importmanager/pkg/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// StatusInterval How often the pool status is written to the state directory
+const StatusInterval = 1 * time.Second
+
+// StatusFile The name of the status file inside the state directory
+const StatusFile = "status.json"
+
+var engine *pool = newPool()
+
+func newPool() (p *pool) {
+	p = &pool{
+		queue:    make([]*task, 0),
+		paths:    make(map[string]int),
+		handlers: make(map[string]int),
+		devices:  make(map[uint64]int),
+		retries:  make(map[*task]*time.Timer),
+		states:   make(map[string]string),
+	}
+	p.cond = sync.NewCond(&p.Mutex)
+	return
+}
+
+// configure Apply the worker count and limits from the configuration
+//
+// Workers are started when the count grows. When it shrinks, surplus
+// workers exit as soon as they are idle.
+func (p *pool) configure(config *c.Config) {
+	p.Lock()
+	defer p.Unlock()
+	p.size = config.Workers
+	p.limits = config.Limits
+	p.aging = config.Scheduling.Aging * time.Second
+	switch p.policy = config.Scheduling.Policy; p.policy {
+	case c.PolicyFIFO, c.PolicySmallestFirst:
+	default:
+		log.Errorf("Unknown scheduling policy '%s'. Using '%s'", p.policy, c.PolicyFIFO)
+		p.policy = c.PolicyFIFO
+	}
+	if config.StateDirectory != "" {
+		p.status = filepath.Join(config.StateDirectory, StatusFile)
+	}
+	p.quarantine = config.Quarantine
+	for ; p.started < p.size; p.started++ {
+		log.Debugf("Starting worker %d", p.started)
+		go p.worker()
+	}
+	p.dirty = true
+	p.cond.Broadcast()
+}
+
+// submit Add a task to the queue
+//
+// A task which is resubmitted once its processor is found keeps the time
+// it was first queued so it does not lose its place.
+func (p *pool) submit(t *task) {
+	p.Lock()
+	defer p.Unlock()
+	if t.queued.IsZero() {
+		t.queued = time.Now()
+	}
+	p.queue = append(p.queue, t)
+	p.dirty = true
+	p.cond.Broadcast()
+}
+
+// cancel Drop all queued tasks for a watched path
+func (p *pool) cancel(path string) {
+	p.Lock()
+	defer p.Unlock()
+	var queue []*task = make([]*task, 0, len(p.queue))
+	for _, t := range p.queue {
+		if t.watched.Path != path {
+			queue = append(queue, t)
+		}
+	}
+	p.queue = queue
+	for t, timer := range p.retries {
+		if t.watched.Path == path {
+			timer.Stop()
+			delete(p.retries, t)
+		}
+	}
+	p.dirty = true
+}
+
+// setState Record the health of a watched path. An empty state forgets the path
+func (p *pool) setState(path, state string) {
+	p.Lock()
+	defer p.Unlock()
+	if state == "" {
+		delete(p.states, path)
+	} else {
+		p.states[path] = state
+	}
+	p.dirty = true
+}
+
+// quarantineDirectory Where files are moved when their retries are used up
+func (p *pool) quarantineDirectory() string {
+	p.Lock()
+	defer p.Unlock()
+	return p.quarantine
+}
+
+// retry Put a failed task back into the queue once its backoff expires
+func (p *pool) retry(t *task) {
+	p.Lock()
+	defer p.Unlock()
+	var delay time.Duration = t.backoff()
+	log.Infof("Retrying %s in %s (attempt %d of %d)", t.path, delay.Round(time.Second), t.attempt+1, t.processor.Retry.Attempts)
+	p.retries[t] = time.AfterFunc(delay, func() {
+		p.Lock()
+		_, ok := p.retries[t]
+		delete(p.retries, t)
+		p.Unlock()
+		if ok {
+			p.submit(t)
+		}
+	})
+	p.dirty = true
+}
+
+// wait Block until no tasks for a watched path are running
+func (p *pool) wait(path string) {
+	p.Lock()
+	defer p.Unlock()
+	for p.paths[path] > 0 {
+		p.cond.Wait()
+	}
+}
+
+// stop Shut down all workers once their current task completes
+func (p *pool) stop() {
+	p.Lock()
+	defer p.Unlock()
+	p.size = 0
+	p.cond.Broadcast()
+	for p.started > 0 {
+		p.cond.Wait()
+	}
+	p.report()
+}
+
+func (p *pool) worker() {
+	for {
+		var t *task = p.take()
+		if t == nil {
+			return
+		}
+		var o outcome = t.run()
+		p.release(t)
+		switch o {
+		case resolved:
+			p.submit(t)
+		case retrying:
+			p.retry(t)
+		default:
+			t.done(o == handled)
+		}
+	}
+}
+
+// take Wait for the first queued task which can run within the limits
+func (p *pool) take() *task {
+	p.Lock()
+	defer p.Unlock()
+	for {
+		if p.started > p.size {
+			p.started--
+			p.cond.Broadcast()
+			return nil
+		}
+		if p.active < p.size {
+			if i := p.next(); i >= 0 {
+				var t *task = p.queue[i]
+				p.queue = append(p.queue[:i], p.queue[i+1:]...)
+				p.acquire(t, 1)
+				return t
+			}
+		}
+
+		// Aging can change the order without any task finishing
+		// so the queue is looked at again after the aging period
+		if len(p.queue) > 0 && p.aging > 0 {
+			var timer *time.Timer = time.AfterFunc(p.aging, p.cond.Broadcast)
+			p.cond.Wait()
+			timer.Stop()
+			continue
+		}
+		p.cond.Wait()
+	}
+}
+
+// next Find the queued task to run next
+//
+// Tasks are ordered by priority, raised by one for every aging period spent
+// waiting in the queue, then by size for the `smallest-first` policy and
+// finally by the time of the last event for the file.
+//
+// Return:
+//
+// - int The index of the task in the queue or -1 if none can run
+func (p *pool) next() (best int) {
+	var (
+		now  time.Time = time.Now()
+		rank int
+	)
+	best = -1
+	for i, t := range p.queue {
+		if !p.runnable(t) {
+			continue
+		}
+		var r int = t.priority()
+		if p.aging > 0 {
+			r += int(now.Sub(t.queued) / p.aging)
+		}
+		if best < 0 || p.before(t, r, p.queue[best], rank) {
+			best, rank = i, r
+		}
+	}
+	return
+}
+
+func (p *pool) before(a *task, ra int, b *task, rb int) bool {
+	if ra != rb {
+		return ra > rb
+	}
+	if p.policy == c.PolicySmallestFirst && a.size != b.size {
+		return a.size < b.size
+	}
+	return a.event.time.Before(b.event.time)
+}
+
+// priority The priority of the processor for a task. Tasks which are not
+// yet resolved have the default priority
+func (t *task) priority() int {
+	if t.processor == nil {
+		return 0
+	}
+	return t.processor.Priority
+}
+
+// release Return the slots used by a task
+func (p *pool) release(t *task) {
+	p.Lock()
+	defer p.Unlock()
+	p.acquire(t, -1)
+	p.cond.Broadcast()
+}
+
+func (p *pool) acquire(t *task, n int) {
+	p.active += n
+	p.paths[t.watched.Path] += n
+	if t.processor != nil {
+		p.handlers[t.handler] += n
+		if t.device != 0 {
+			p.devices[t.device] += n
+		}
+	}
+	p.dirty = true
+}
+
+// runnable Test a task against the path, handler and device limits
+func (p *pool) runnable(t *task) bool {
+	if l := t.watched.Workers; l > 0 && p.paths[t.watched.Path] >= l {
+		return false
+	}
+	if t.processor == nil {
+		return true
+	}
+	if l := p.limits.Handlers[t.handler]; l > 0 && p.handlers[t.handler] >= l {
+		return false
+	}
+	if l := p.limits.PerDevice; l > 0 && t.device != 0 && p.devices[t.device] >= l {
+		return false
+	}
+	return true
+}
+
+// snapshot Build the current status. The pool must be locked
+func (p *pool) snapshot() (s Status) {
+	s = Status{
+		Workers:  p.size,
+		Active:   p.active,
+		Queued:   len(p.queue),
+		Retrying: len(p.retries),
+		Paths:    make(map[string]PathStatus),
+		Handlers: make(map[string]int),
+		Updated:  time.Now(),
+	}
+	for path, state := range p.states {
+		s.Paths[path] = PathStatus{State: state}
+	}
+	for path, n := range p.paths {
+		if n > 0 {
+			var ps PathStatus = s.Paths[path]
+			ps.Active = n
+			s.Paths[path] = ps
+		}
+	}
+	for _, t := range p.queue {
+		var ps PathStatus = s.Paths[t.watched.Path]
+		ps.Queued++
+		s.Paths[t.watched.Path] = ps
+	}
+	for h, n := range p.handlers {
+		if n > 0 {
+			s.Handlers[h] = n
+		}
+	}
+	return
+}
+
+// reporter Periodically write the status to the state directory
+func (p *pool) reporter(done chan bool) {
+	var ticker *time.Ticker = time.NewTicker(StatusInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			p.Lock()
+			if p.dirty {
+				p.report()
+			}
+			p.Unlock()
+		}
+	}
+}
+
+// report Write the status file. The pool must be locked
+func (p *pool) report() {
+	p.dirty = false
+	if p.status == "" {
+		return
+	}
+	data, err := json.MarshalIndent(p.snapshot(), "", "  ")
+	if err != nil {
+		return
+	}
+	if err = os.MkdirAll(filepath.Dir(p.status), 0750); err != nil {
+		log.Errorf("Unable to write status - %s", err.Error())
+		return
+	}
+	var tmp string = p.status + ".tmp"
+	if err = ioutil.WriteFile(tmp, data, 0640); err == nil {
+		err = os.Rename(tmp, p.status)
+	}
+	if err != nil {
+		log.Errorf("Unable to write status - %s", err.Error())
+	}
+}
+
+// CurrentStatus Get the queue depth and active workers of the worker pool
+func CurrentStatus() Status {
+	engine.Lock()
+	defer engine.Unlock()
+	return engine.snapshot()
+}
+
+// ReadStatus Read the status last written by a running instance
+//
+// Arguments:
+//
+// - stateDirectory string The state directory of the running instance
+//
+// Return:
+//
+// - Status The last written status
+// - error  Any error reading the status file
+func ReadStatus(stateDirectory string) (s Status, err error) {
+	var data []byte
+	if data, err = ioutil.ReadFile(filepath.Join(stateDirectory, StatusFile)); err != nil {
+		return
+	}
+	err = json.Unmarshal(data, &s)
+	return
+}
+
+// destinationDevice Find the device a processor writes to
+//
+// The nearest existing parent of the static part of the destination is
+// used as the destination may not exist yet.
+func destinationDevice(processor *c.Processor) uint64 {
+	var dir string = c.StaticRoot(processor.Path)
+	for dir != "" {
+		if fi, err := os.Stat(dir); err == nil {
+			if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+				return uint64(st.Dev)
+			}
+			return 0
+		}
+		var parent string = filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+	return 0
+}
@@ -0,0 +1,318 @@
+package handler
+
+import (
+	"bufio"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	re "regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	c "github.com/mproffitt/importmanager/pkg/config"
+	p "github.com/mproffitt/importmanager/pkg/processing"
+	log "github.com/sirupsen/logrus"
+)
+
+// Suffixes used by browsers and download managers for incomplete files
+var partialSuffixes = []string{
+	".crdownload",
+	".part",
+	".partial",
+	".download",
+	".opdownload",
+	p.PartialSuffix,
+}
+
+// Temporary files written by rsync are named `.<name>.XXXXXX`
+var rsyncTemp *re.Regexp = re.MustCompile(`^\..+\.([A-Za-z0-9]{6})$`)
+
+// readinessChecks The set of available readiness checks by name
+//
+// To add a new check, implement `readinessCheck` and register a
+// constructor for it here.
+var readinessChecks = map[string]func(r c.Readiness) readinessCheck{
+	"partial": func(r c.Readiness) readinessCheck {
+		return partialCheck{}
+	},
+	"stable": func(r c.Readiness) readinessCheck {
+		return stableCheck{
+			samples:  r.Samples,
+			interval: r.SampleInterval * time.Millisecond,
+		}
+	},
+	"writers": func(r c.Readiness) readinessCheck {
+		return writersCheck{}
+	},
+	"closewrite": func(r c.Readiness) readinessCheck {
+		return closeWriteCheck{}
+	},
+}
+
+// checkReadiness Run the configured readiness checks against a path
+//
+// Checks are run in the order they are configured and stop at the first
+// check which does not report the file as ready.
+//
+// Arguments:
+//
+// - path string           The file to test
+// - e    event            The last event seen for the file
+// - r    config.Readiness The readiness configuration for the watched path
+//
+// Return:
+//
+// - readiness Whether the file is ready, not ready or should be ignored
+// - string    The reason the file is not ready
+func checkReadiness(path string, e event, r c.Readiness) (readiness, string) {
+	var (
+		fi     os.FileInfo
+		err    error
+		checks []string = r.Checks
+	)
+	if fi, err = os.Stat(path); err != nil {
+		return ignore, err.Error()
+	}
+
+	// Directories are only handled once their contents stop changing
+	if fi.IsDir() && !containsFold("stable", checks) {
+		checks = append(append(make([]string, 0, len(checks)+1), checks...), "stable")
+	}
+
+	for _, name := range checks {
+		var constructor, ok = readinessChecks[strings.ToLower(name)]
+		if !ok {
+			log.Errorf("Unknown readiness check '%s'", name)
+			continue
+		}
+		if state, reason := constructor(r).check(path, e); state != ready {
+			return state, fmt.Sprintf("%s: %s", name, reason)
+		}
+	}
+	return ready, ""
+}
+
+// partialCheck ignores incomplete download files and waits for files which
+// have a companion partial or lock file
+type partialCheck struct{}
+
+func (partialCheck) check(path string, e event) (readiness, string) {
+	var (
+		dir  string = filepath.Dir(path)
+		name string = filepath.Base(path)
+	)
+
+	if isPartial(name) {
+		return ignore, "file is incomplete or temporary"
+	}
+	// Files inside a directory importmanager is still copying carry
+	// their final names
+	for d := dir; d != filepath.Dir(d); d = filepath.Dir(d) {
+		if strings.HasSuffix(filepath.Base(d), p.PartialSuffix) {
+			return ignore, fmt.Sprintf("%s is still being copied", d)
+		}
+	}
+
+	var companions []string = []string{
+		"~$" + name,
+		".~lock." + name + "#",
+	}
+	if len(name) > 2 {
+		companions = append(companions, "~$"+name[2:])
+	}
+	for _, suffix := range partialSuffixes {
+		companions = append(companions, name+suffix)
+	}
+	for _, companion := range companions {
+		if _, err := os.Lstat(filepath.Join(dir, companion)); err == nil {
+			return notReady, fmt.Sprintf("companion file %s exists", companion)
+		}
+	}
+
+	matches, _ := filepath.Glob(filepath.Join(dir, "."+escapeGlob(name)+".??????"))
+	for _, match := range matches {
+		if isRsyncTemp(filepath.Base(match)) {
+			return notReady, fmt.Sprintf("rsync temporary file %s exists", filepath.Base(match))
+		}
+	}
+	return ready, ""
+}
+
+// isPartial Test if a filename is a partial download, lock or temporary file
+func isPartial(name string) bool {
+	for _, suffix := range partialSuffixes {
+		if strings.HasSuffix(strings.ToLower(name), suffix) {
+			return true
+		}
+	}
+	return strings.HasPrefix(name, "~$") || strings.HasPrefix(name, ".~lock.") || isRsyncTemp(name)
+}
+
+// isRsyncTemp Test if a filename is an rsync temporary file
+//
+// The random suffix rsync adds mixes upper case with lower case or digits.
+// Dotfiles with an ordinary six letter extension such as `.notes.backup`
+// are not matched.
+func isRsyncTemp(name string) bool {
+	var match []string = rsyncTemp.FindStringSubmatch(name)
+	if match == nil {
+		return false
+	}
+	return strings.ContainsAny(match[1], "ABCDEFGHIJKLMNOPQRSTUVWXYZ") &&
+		strings.ContainsAny(match[1], "abcdefghijklmnopqrstuvwxyz0123456789")
+}
+
+func containsFold(what string, where []string) bool {
+	for _, p := range where {
+		if strings.EqualFold(what, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func isDir(path string) bool {
+	if fi, err := os.Stat(path); err == nil {
+		return fi.IsDir()
+	}
+	return false
+}
+
+func escapeGlob(name string) string {
+	var r = strings.NewReplacer("*", "\\*", "?", "\\?", "[", "\\[", "]", "\\]", "\\", "\\\\")
+	return r.Replace(name)
+}
+
+// stableCheck samples the size and modification time of a file and reports
+// it as ready when these have not changed over all samples
+type stableCheck struct {
+	samples  int
+	interval time.Duration
+}
+
+func (s stableCheck) check(path string, e event) (readiness, string) {
+	var last *signature
+	for i := 0; i < s.samples; i++ {
+		if i > 0 {
+			<-time.After(s.interval)
+		}
+		current, err := sign(path)
+		if err != nil {
+			return ignore, err.Error()
+		}
+		if last != nil && *current != *last {
+			return notReady, "file is still changing"
+		}
+		last = current
+	}
+	return ready, ""
+}
+
+// signature The size, latest modification time and number of files of a
+// file or directory tree
+type signature struct {
+	size    int64
+	modtime time.Time
+	count   int
+}
+
+func sign(path string) (s *signature, err error) {
+	var fi os.FileInfo
+	if fi, err = os.Stat(path); err != nil {
+		return
+	}
+	s = &signature{size: fi.Size(), modtime: fi.ModTime(), count: 1}
+	if !fi.IsDir() {
+		return
+	}
+
+	err = filepath.WalkDir(path, func(p string, d fs.DirEntry, e error) error {
+		if e != nil {
+			return e
+		}
+		info, e := d.Info()
+		if e != nil {
+			return e
+		}
+		s.size += info.Size()
+		s.count++
+		if info.ModTime().After(s.modtime) {
+			s.modtime = info.ModTime()
+		}
+		return nil
+	})
+	return
+}
+
+// writersCheck scans `/proc/*/fd` for any process holding the file open for writing
+type writersCheck struct{}
+
+func (w writersCheck) check(path string, e event) (readiness, string) {
+	procs, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return ready, ""
+	}
+
+	for _, proc := range procs {
+		var pid int
+		if pid, err = strconv.Atoi(proc.Name()); err != nil {
+			continue
+		}
+		var fdDir string = filepath.Join("/proc", proc.Name(), "fd")
+		fds, err := ioutil.ReadDir(fdDir)
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			target, err := os.Readlink(filepath.Join(fdDir, fd.Name()))
+			if err != nil || (target != path && !strings.HasPrefix(target, path+string(filepath.Separator))) {
+				continue
+			}
+			if openForWriting(filepath.Join("/proc", proc.Name(), "fdinfo", fd.Name())) {
+				return notReady, fmt.Sprintf("open for writing by pid %d", pid)
+			}
+		}
+	}
+	return ready, ""
+}
+
+// openForWriting reads the flags from a `/proc/<pid>/fdinfo/<fd>` file
+func openForWriting(fdinfo string) bool {
+	f, err := os.Open(fdinfo)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var line string = scanner.Text()
+		if !strings.HasPrefix(line, "flags:") {
+			continue
+		}
+		flags, err := strconv.ParseUint(strings.TrimSpace(strings.TrimPrefix(line, "flags:")), 8, 64)
+		if err != nil {
+			return false
+		}
+		return flags&uint64(os.O_WRONLY|os.O_RDWR) != 0
+	}
+	return false
+}
+
+// closeWriteCheck waits for `IN_CLOSE_WRITE` after the file was last written
+//
+// Files which were moved into the path without being written are ready.
+type closeWriteCheck struct{}
+
+func (w closeWriteCheck) check(path string, e event) (readiness, string) {
+	if isDir(path) {
+		return ready, ""
+	}
+	if e.written && !e.closed {
+		return notReady, "waiting for IN_CLOSE_WRITE"
+	}
+	return ready, ""
+}
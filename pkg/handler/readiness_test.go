@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIsPartial(t *testing.T) {
+	var tests = []struct {
+		name    string
+		partial bool
+	}{
+		{name: "film.mkv.crdownload", partial: true},
+		{name: "film.mkv.PART", partial: true},
+		{name: "~$report.docx", partial: true},
+		{name: ".~lock.report.odt#", partial: true},
+		{name: ".report.pdf.a1B2c3", partial: true},
+		{name: ".report.pdf.AbCdEf", partial: true},
+		{name: ".notes.backup"},
+		{name: ".config.yaml.BACKUP"},
+		{name: ".bashrc"},
+		{name: "report.pdf"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isPartial(tt.name); got != tt.partial {
+				t.Errorf("expected %t, got %t", tt.partial, got)
+			}
+		})
+	}
+}
+
+func TestPartialCheck(t *testing.T) {
+	var tests = []struct {
+		name      string
+		file      string
+		companion string
+		state     readiness
+	}{
+		{name: "complete", file: "report.pdf", state: ready},
+		{name: "partial download", file: "report.pdf.part", state: ignore},
+		{name: "companion download", file: "report.pdf", companion: "report.pdf.crdownload", state: notReady},
+		{name: "office lock file", file: "report.odt", companion: ".~lock.report.odt#", state: notReady},
+		{name: "rsync temporary", file: "report.pdf", companion: ".report.pdf.x7Yz9Q", state: notReady},
+		{name: "ordinary dotfile", file: "report.pdf", companion: ".report.pdf.backup", state: ready},
+		{name: "inside a directory being copied", file: "album.importmanager-partial/photo.jpg", state: ignore},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				dir  string = t.TempDir()
+				path string = filepath.Join(dir, tt.file)
+			)
+			if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range []string{tt.file, tt.companion} {
+				if name == "" {
+					continue
+				}
+				if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("content"), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if state, reason := (partialCheck{}).check(path, event{}); state != tt.state {
+				t.Errorf("expected state %d, got %d (%s)", tt.state, state, reason)
+			}
+		})
+	}
+}
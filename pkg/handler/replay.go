@@ -0,0 +1,157 @@
+package handler
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	c "github.com/mproffitt/importmanager/pkg/config"
+	"github.com/mproffitt/importmanager/pkg/journal"
+	m "github.com/mproffitt/importmanager/pkg/mime"
+	p "github.com/mproffitt/importmanager/pkg/processing"
+)
+
+// Replay Work out what a configuration would do with a file recorded in
+// the journal
+//
+// Processor selection and destination templating are run for the file
+// under config as they would be when it is handled. Nothing is written. A
+// file which has since been moved is dated from its recorded destination.
+//
+// Collisions with existing files are only resolved when a file is handled.
+// Files are compared by where they would have been written had nothing
+// existed there, which the journal records as the target.
+//
+// Arguments:
+//
+// - r      journal.Record The latest record of the job
+// - config *config.Config The configuration to replay the file against
+//
+// Return:
+//
+// - Replayed What happened and what would happen
+// - bool     False if the job had not finished and cannot be compared
+func Replay(r journal.Record, config *c.Config) (replayed Replayed, ok bool) {
+	switch r.State {
+	case journal.Completed, journal.Failed, journal.Observed:
+	case journal.Skipped:
+		// Files skipped before their type was known were not ready
+		if r.Error != unknownType && r.Error != noProcessor {
+			return
+		}
+	default:
+		return
+	}
+	replayed = Replayed{Path: r.Path, Watched: r.Watched, Before: recorded(r, r.Destination)}
+	if replayed.After, replayed.Error = plan(r, config); replayed.Error == "" {
+		var target string = r.Destination
+		if r.Target != "" {
+			target = r.Target
+		}
+		replayed.Changed = recorded(r, target) != replayed.After
+	}
+	return replayed, true
+}
+
+// plan Describe what a configuration would do with a file recorded in the
+// journal
+//
+// Return:
+//
+// - string The action which would be taken
+// - string Why the file could not be replayed, if it could not
+func plan(r journal.Record, config *c.Config) (string, string) {
+	var watched *c.Path
+	for i := range config.Paths {
+		if config.Paths[i].Path == r.Watched {
+			watched = &config.Paths[i]
+			break
+		}
+	}
+	if watched == nil {
+		return "not watched", ""
+	}
+
+	var from string = r.Path
+	if _, err := os.Lstat(from); err != nil && r.Destination != "" {
+		if _, err := os.Lstat(r.Destination); err == nil {
+			from = r.Destination
+		}
+	}
+	var fi, _ = os.Stat(from)
+	if excluded, reason := newPathFilter(watched).excluded(r.Path, fi != nil && fi.IsDir()); excluded {
+		return "ignored: " + reason, ""
+	}
+	if r.Processor == nil && r.State == journal.Completed && r.Size == 0 && config.CleanupZeroByte {
+		return action(nil, "", ""), ""
+	}
+
+	var details *m.Details
+	if r.Details != nil {
+		var d m.Details = *r.Details
+		details = &d
+	} else if fi != nil && !fi.IsDir() {
+		if details = m.Catagories.FindBestMatchFor(from); details == nil || details.Type == Partial {
+			return "skipped: " + unknownType, ""
+		}
+	}
+	if details == nil {
+		return "", "the type is not recorded and the file no longer exists"
+	}
+
+	var processor *c.Processor = FindProcessor(details, watched.Processors)
+	if processor == nil {
+		return "skipped: " + noProcessor, ""
+	}
+	destination, err := p.PlanFrom(r.Path, from, watched.Path, details, processor)
+	var failure string
+	if err != nil {
+		failure = err.Error()
+	}
+	return action(processor, destination, failure), ""
+}
+
+// recorded Describe what the journal records as having happened to a file
+// written to destination
+func recorded(r journal.Record, destination string) string {
+	switch {
+	case r.State == journal.Skipped:
+		return "skipped: " + r.Error
+	case r.Processor == nil:
+		return action(nil, "", "")
+	}
+	return action(r.Processor, destination, r.Error)
+}
+
+// action Describe what was or would be done to a file
+//
+// Plugins decide their own filename so only the plugin is described.
+//
+// Arguments:
+//
+// - processor   *config.Processor The processor for the file. Nil for an empty file
+// - destination string            Where the file was or would be written
+// - failure     string            The error the processor failed with, if any
+//
+// Return:
+//
+// - string A description such as `moved to /images/a.jpg`
+func action(processor *c.Processor, destination, failure string) string {
+	if processor == nil {
+		return "deleted as it is empty"
+	}
+
+	var handler string = filepath.Base(processor.Handler)
+	if failure != "" {
+		return fmt.Sprintf("failed with %s: %s", handler, failure)
+	}
+	verb, ok := verbs[strings.ToLower(handler)]
+	switch {
+	case !ok:
+		return fmt.Sprintf("passed to plugin %s", handler)
+	case destination == "":
+		return verb
+	}
+	return fmt.Sprintf("%s to %s", verb, destination)
+}
@@ -0,0 +1,177 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	c "github.com/mproffitt/importmanager/pkg/config"
+	"github.com/mproffitt/importmanager/pkg/journal"
+	p "github.com/mproffitt/importmanager/pkg/processing"
+	log "github.com/sirupsen/logrus"
+)
+
+// SidecarSuffix Added to the name of a quarantined file for its description
+const SidecarSuffix = ".json"
+
+func init() {
+	rand.Seed(time.Now().UnixNano())
+}
+
+// failed Decide what happens to a task whose processor returned an error
+//
+// The task is retried until the processor's retry attempts are used up,
+// after which the file is moved to the quarantine directory.
+func (t *task) failed(err error) outcome {
+	if _, e := os.Lstat(t.path); e != nil {
+		log.Errorf("Not retrying %s - source no longer exists", t.path)
+		journal.Finish(t.path, err)
+		return handled
+	}
+
+	if t.attempt < t.processor.Retry.Attempts {
+		journal.Retry(t.path, err, t.attempt)
+		return retrying
+	}
+
+	log.Errorf("Giving up on %s after %d attempts - %s", t.path, t.attempt, err.Error())
+	journal.Finish(t.path, err)
+	if final, e := quarantine(t, engine.quarantineDirectory(), err); e != nil {
+		log.Errorf("Unable to quarantine %s - %s", t.path, e.Error())
+	} else {
+		t.notify(fmt.Sprintf("Quarantined %s after %d attempts: %s", filepath.Base(t.path), t.attempt, err.Error()))
+		log.Warnf("Quarantined %s as %s", t.path, final)
+	}
+	return handled
+}
+
+// backoff The time to wait before the next attempt
+//
+// The wait doubles with each attempt up to the processor's `maxBackoff`.
+// Half of the wait is randomised so retries of many files spread out.
+func (t *task) backoff() time.Duration {
+	var (
+		retry c.Retry       = t.processor.Retry
+		delay time.Duration = retry.Backoff * time.Second
+		limit time.Duration = retry.MaxBackoff * time.Second
+	)
+	for i := 1; i < t.attempt && delay < limit; i++ {
+		delay *= 2
+	}
+	if limit > 0 && delay > limit {
+		delay = limit
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// quarantine Move a file into the quarantine directory with a sidecar
+// describing why
+func quarantine(t *task, dir string, cause error) (final string, err error) {
+	if dir == "" {
+		return "", fmt.Errorf("no quarantine directory configured")
+	}
+	if final, err = p.Relocate(t.path, dir); err != nil {
+		return
+	}
+
+	var q Quarantined = Quarantined{
+		Source:    t.path,
+		Watched:   t.watched.Path,
+		Path:      final,
+		Time:      time.Now(),
+		Attempts:  t.attempt,
+		Error:     cause.Error(),
+		Details:   t.details,
+		Processor: t.processor,
+	}
+	var data []byte
+	if data, err = json.MarshalIndent(q, "", "  "); err != nil {
+		return
+	}
+	err = ioutil.WriteFile(final+SidecarSuffix, data, 0640)
+	return
+}
+
+// ListQuarantine Read the description of each quarantined file
+//
+// Arguments:
+//
+// - dir string The quarantine directory
+//
+// Return:
+//
+// - []Quarantined The quarantined files, oldest first
+// - error         Any error reading the directory
+func ListQuarantine(dir string) (list []Quarantined, err error) {
+	list = make([]Quarantined, 0)
+	var entries []os.FileInfo
+	if entries, err = ioutil.ReadDir(dir); err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), SidecarSuffix) {
+			continue
+		}
+		var sidecar string = filepath.Join(dir, e.Name())
+		data, err := ioutil.ReadFile(sidecar)
+		if err != nil {
+			continue
+		}
+		var q Quarantined
+		if err = json.Unmarshal(data, &q); err != nil || q.Path != strings.TrimSuffix(sidecar, SidecarSuffix) {
+			continue
+		}
+		list = append(list, q)
+	}
+	sort.Slice(list, func(a, b int) bool {
+		return list[a].Time.Before(list[b].Time)
+	})
+	return
+}
+
+// Release Move a quarantined file back to where it was found so it is
+// handled again
+//
+// If a file already exists at the original location, a number is added
+// to the name.
+//
+// Return:
+//
+// - string The path the file was moved to
+// - error  Any error moving the file
+func Release(q Quarantined) (final string, err error) {
+	if _, err = os.Lstat(q.Path); err != nil {
+		return
+	}
+
+	var dir string = filepath.Dir(q.Source)
+	if _, err = os.Stat(dir); err != nil {
+		dir = q.Watched
+	}
+
+	if _, e := os.Lstat(q.Source); e != nil {
+		if err = os.Rename(q.Path, q.Source); err == nil {
+			final = q.Source
+		}
+	}
+	if final == "" {
+		if final, err = p.Relocate(q.Path, dir); err != nil {
+			return
+		}
+	}
+	os.Remove(q.Path + SidecarSuffix)
+	return
+}
@@ -0,0 +1,52 @@
+package handler
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestListQuarantineIsOldestFirst(t *testing.T) {
+	var (
+		dir  string    = t.TempDir()
+		now  time.Time = time.Now()
+		list []Quarantined
+		err  error
+	)
+	for name, age := range map[string]time.Duration{"b.pdf": time.Hour, "a.pdf": time.Minute, "c.pdf": 2 * time.Hour} {
+		var path string = filepath.Join(dir, name)
+		data, err := json.Marshal(Quarantined{Path: path, Time: now.Add(-age)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = ioutil.WriteFile(path+SidecarSuffix, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A sidecar describing a different file is not listed
+	if err = ioutil.WriteFile(filepath.Join(dir, "d.pdf"+SidecarSuffix), []byte(`{"path":"/elsewhere/d.pdf"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if list, err = ListQuarantine(dir); err != nil {
+		t.Fatal(err)
+	}
+	var expected []string = []string{"c.pdf", "b.pdf", "a.pdf"}
+	if len(list) != len(expected) {
+		t.Fatalf("expected %d entries, got %d", len(expected), len(list))
+	}
+	for i, name := range expected {
+		if got := filepath.Base(list[i].Path); got != name {
+			t.Errorf("expected %s at %d, got %s", name, i, got)
+		}
+	}
+}
+
+func TestListQuarantineMissingDirectory(t *testing.T) {
+	list, err := ListQuarantine(filepath.Join(t.TempDir(), "missing"))
+	if err != nil || len(list) != 0 {
+		t.Errorf("expected an empty list, got %v, %v", list, err)
+	}
+}
@@ -0,0 +1,187 @@
+package handler
+
+import (
+	"container/heap"
+	"time"
+
+	"github.com/rjeczalik/notify"
+)
+
+// newScheduler Create a scheduler which dispatches paths once they have
+// received no events for delay
+func newScheduler(delay time.Duration) *scheduler {
+	return &scheduler{
+		delay:   delay,
+		pending: make(map[string]*entry),
+		queue:   make(timerHeap, 0),
+		wake:    make(chan bool, 1),
+	}
+}
+
+// record Add or update the event for a path and restart its quiet period
+func (s *scheduler) record(path string, ev notify.Event) {
+	s.Lock()
+	var e, ok = s.pending[path]
+	if !ok {
+		e = &entry{path: path}
+		s.pending[path] = e
+		heap.Push(&s.queue, e)
+	}
+	e.event.event, e.event.time = ev, time.Now()
+	switch ev {
+	case notify.InCloseWrite:
+		e.event.closed = true
+	case notify.Write, notify.InModify:
+		e.event.written, e.event.closed = true, false
+	}
+	e.due = e.event.time.Add(s.delay)
+	heap.Fix(&s.queue, e.index)
+	s.Unlock()
+	s.signal()
+}
+
+// requeue Put a path which was not ready back into the queue
+//
+// If a newer event has been received for the path in the meantime, that
+// event is kept instead.
+func (s *scheduler) requeue(path string, ev event) {
+	s.Lock()
+	if _, ok := s.pending[path]; ok {
+		s.Unlock()
+		return
+	}
+	var e *entry = &entry{path: path, event: ev, due: time.Now().Add(s.delay)}
+	s.pending[path] = e
+	heap.Push(&s.queue, e)
+	s.Unlock()
+	s.signal()
+}
+
+// remove Forget any pending event for a path
+func (s *scheduler) remove(path string) {
+	s.Lock()
+	defer s.Unlock()
+	if e, ok := s.pending[path]; ok {
+		heap.Remove(&s.queue, e.index)
+		delete(s.pending, path)
+	}
+}
+
+// signal Wake the scheduler so it recalculates the next due time
+func (s *scheduler) signal() {
+	select {
+	case s.wake <- true:
+	default:
+	}
+}
+
+// next Take the earliest path from the queue if its quiet period is over
+//
+// Return:
+//
+// - *entry        The entry to dispatch or nil if nothing is due
+// - time.Duration How long until the next entry is due
+// - bool          False if the queue is empty
+func (s *scheduler) next() (*entry, time.Duration, bool) {
+	s.Lock()
+	defer s.Unlock()
+	if len(s.queue) == 0 {
+		return nil, 0, false
+	}
+
+	var wait time.Duration = time.Until(s.queue[0].due)
+	if wait > 0 {
+		return nil, wait, true
+	}
+
+	var e *entry = heap.Pop(&s.queue).(*entry)
+	delete(s.pending, e.path)
+	s.active++
+	return e, 0, true
+}
+
+// run Dispatch each path exactly when its quiet period expires
+//
+// Blocks until done is closed.
+//
+// Arguments:
+//
+// - dispatch func(string, event) Called for every path which is due
+// - done     chan bool           Close to stop the scheduler
+func (s *scheduler) run(dispatch func(string, event), done chan bool) {
+	var timer *time.Timer = time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		e, wait, ok := s.next()
+		if e != nil {
+			dispatch(e.path, e.event)
+			continue
+		}
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		var expired <-chan time.Time
+		if ok {
+			timer.Reset(wait)
+			expired = timer.C
+		}
+
+		select {
+		case <-done:
+			return
+		case <-s.wake:
+		case <-expired:
+		}
+	}
+}
+
+// finished Mark a dispatched path as done
+//
+// Arguments:
+//
+// - handled bool True if the path was processed rather than skipped or requeued
+//
+// Return:
+//
+// - int The number of paths handled in the batch if this completed it, otherwise 0
+func (s *scheduler) finished(handled bool) (batch int) {
+	s.Lock()
+	defer s.Unlock()
+	s.active--
+	if handled {
+		s.handled++
+	}
+	if s.active == 0 && len(s.queue) == 0 && s.handled > 0 {
+		batch, s.handled = s.handled, 0
+	}
+	return
+}
+
+func (h timerHeap) Len() int           { return len(h) }
+func (h timerHeap) Less(i, j int) bool { return h[i].due.Before(h[j].due) }
+func (h timerHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index, h[j].index = i, j
+}
+
+func (h *timerHeap) Push(x interface{}) {
+	var e *entry = x.(*entry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *timerHeap) Pop() interface{} {
+	var (
+		old timerHeap = *h
+		n   int       = len(old)
+		e   *entry    = old[n-1]
+	)
+	old[n-1] = nil
+	*h = old[:n-1]
+	e.index = -1
+	return e
+}
@@ -0,0 +1,82 @@
+package handler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rjeczalik/notify"
+)
+
+func TestSchedulerCoalescesEvents(t *testing.T) {
+	var s *scheduler = newScheduler(0)
+	s.record("/watched/a.pdf", notify.Write)
+	s.record("/watched/a.pdf", notify.InCloseWrite)
+	s.record("/watched/b.pdf", notify.Create)
+
+	e, _, ok := s.next()
+	if !ok || e == nil || e.path != "/watched/a.pdf" {
+		t.Fatalf("expected a.pdf first, got %v", e)
+	}
+	if !e.event.written || !e.event.closed || e.event.event != notify.InCloseWrite {
+		t.Errorf("expected the events to be merged, got %+v", e.event)
+	}
+	if e, _, _ = s.next(); e == nil || e.path != "/watched/b.pdf" {
+		t.Fatalf("expected b.pdf second, got %v", e)
+	}
+	if e, _, ok = s.next(); e != nil || ok {
+		t.Errorf("expected an empty queue, got %v", e)
+	}
+}
+
+func TestSchedulerWaitsForTheQuietPeriod(t *testing.T) {
+	var s *scheduler = newScheduler(time.Hour)
+	s.record("/watched/a.pdf", notify.Create)
+
+	e, wait, ok := s.next()
+	if e != nil || !ok || wait <= 0 || wait > time.Hour {
+		t.Errorf("expected a.pdf to wait, got %v after %s", e, wait)
+	}
+
+	s.remove("/watched/a.pdf")
+	if _, _, ok = s.next(); ok {
+		t.Error("expected the queue to be empty after remove")
+	}
+}
+
+func TestSchedulerRequeueKeepsNewerEvents(t *testing.T) {
+	var s *scheduler = newScheduler(0)
+	s.record("/watched/a.pdf", notify.Write)
+	s.requeue("/watched/a.pdf", event{event: notify.Create})
+
+	e, _, _ := s.next()
+	if e == nil || e.event.event != notify.Write {
+		t.Fatalf("expected the recorded event to be kept, got %v", e)
+	}
+
+	s.requeue("/watched/a.pdf", e.event)
+	if e, _, _ = s.next(); e == nil || e.path != "/watched/a.pdf" {
+		t.Errorf("expected a.pdf to be requeued, got %v", e)
+	}
+}
+
+func TestSchedulerBatches(t *testing.T) {
+	var s *scheduler = newScheduler(0)
+	s.record("/watched/a.pdf", notify.Create)
+	s.record("/watched/b.pdf", notify.Create)
+	s.record("/watched/c.pdf", notify.Create)
+	for i := 0; i < 3; i++ {
+		if e, _, _ := s.next(); e == nil {
+			t.Fatalf("expected an entry at %d", i)
+		}
+	}
+
+	if batch := s.finished(true); batch != 0 {
+		t.Errorf("expected no batch while paths are active, got %d", batch)
+	}
+	if batch := s.finished(false); batch != 0 {
+		t.Errorf("expected no batch while paths are active, got %d", batch)
+	}
+	if batch := s.finished(true); batch != 2 {
+		t.Errorf("expected a batch of 2 handled paths, got %d", batch)
+	}
+}
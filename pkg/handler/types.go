@@ -5,29 +5,223 @@ import (
 	"time"
 
 	c "github.com/mproffitt/importmanager/pkg/config"
+	m "github.com/mproffitt/importmanager/pkg/mime"
 	"github.com/rjeczalik/notify"
 )
 
 type event struct {
-	event notify.Event
-	time  time.Time
+	event   notify.Event
+	time    time.Time
+	written bool
+	closed  bool
 }
 
-type watch struct {
-	stop     chan bool
-	complete chan bool
-	events   chan notify.EventInfo
+type readiness int
+
+const (
+	ready readiness = iota
+	notReady
+	ignore
+)
+
+// outcome What happened when a task was run
+type outcome int
+
+const (
+	// skipped The path was not handled
+	skipped outcome = iota
+
+	// handled The path was processed
+	handled
+
+	// resolved The processor was found. The task goes back into the pool
+	resolved
+
+	// retrying The processor failed and will be run again after a backoff
+	retrying
+)
+
+// readinessCheck Tests whether a file is ready to be handled
+type readinessCheck interface {
+	check(path string, e event) (readiness, string)
 }
 
-type job struct {
-	path       string
-	processors []c.Processor
-	czb        bool
-	ready      bool
+type watch struct {
+	definition definition
+	stop       chan bool
 	complete   chan bool
+	events     chan notify.EventInfo
+}
+
+// definition Everything a watcher for a path is started with
+//
+// A watcher is restarted when its definition changes on reload.
+type definition struct {
+	path  c.Path
+	delay time.Duration
+	czb   bool
+}
+
+// task A path waiting in, or being run by, the worker pool
+type task struct {
+	path      string
+	watched   c.Path
+	event     event
+	czb       bool
+	events    *scheduler
+	complete  func(int)
+	notify    func(string)
+	details   *m.Details
+	processor *c.Processor
+	handler   string
+	device    uint64
+	size      int64
+	queued    time.Time
+	attempt   int
+}
+
+// pool The engine wide set of workers shared by all watched paths
+//
+// The number of tasks running at once is capped by `workers`. Tasks may
+// additionally be limited per watched path, per handler and per
+// destination device. The order tasks are taken in is decided by `next`.
+type pool struct {
+	sync.Mutex
+	cond       *sync.Cond
+	size       int
+	started    int
+	limits     c.Limits
+	policy     string
+	aging      time.Duration
+	queue      []*task
+	retries    map[*task]*time.Timer
+	active     int
+	paths      map[string]int
+	handlers   map[string]int
+	devices    map[uint64]int
+	status     string
+	quarantine string
+	states     map[string]string
+	dirty      bool
+}
+
+// Status Queue depth and active workers of the worker pool
+type Status struct {
+	Workers  int                   `json:"workers"`
+	Active   int                   `json:"active"`
+	Queued   int                   `json:"queued"`
+	Retrying int                   `json:"retrying"`
+	Paths    map[string]PathStatus `json:"paths"`
+	Handlers map[string]int        `json:"handlers"`
+	Updated  time.Time             `json:"updated"`
+}
+
+// PathStatus Health, queue depth and active workers for a single watched path
+type PathStatus struct {
+	State  string `json:"state,omitempty"`
+	Active int    `json:"active"`
+	Queued int    `json:"queued"`
+}
+
+// scheduler Holds the pending events for a watched path
+//
+// Each path waits in a heap ordered by the time its quiet period ends.
+// Every new event for a path restarts its quiet period.
+type scheduler struct {
+	sync.Mutex
+	delay   time.Duration
+	pending map[string]*entry
+	queue   timerHeap
+	wake    chan bool
+	active  int
+	handled int
+}
+
+// entry A path waiting in the scheduler
+type entry struct {
+	path  string
+	event event
+	due   time.Time
+	index int
+}
+
+// timerHeap A min-heap of entries ordered by due time
+type timerHeap []*entry
+
+// Quarantined Describes a file moved to the quarantine directory after its
+// processor failed on every attempt
+//
+// This is written next to the file as `<name>.json`.
+type Quarantined struct {
+	Source    string       `json:"source"`
+	Watched   string       `json:"watched"`
+	Path      string       `json:"path"`
+	Time      time.Time    `json:"time"`
+	Attempts  int          `json:"attempts"`
+	Error     string       `json:"error"`
+	Details   *m.Details   `json:"details,omitempty"`
+	Processor *c.Processor `json:"processor,omitempty"`
+}
+
+// routes Tracks the watched paths each piece of content has been handled in
+//
+// Content is identified by its size and modification time, which are kept
+// when files are moved or copied, so files never need to be read.
+type routes struct {
+	sync.Mutex
+	maxHops int
+	window  time.Duration
+	hops    map[string][]hop
+}
+
+// hop A single time content was handled
+type hop struct {
+	watched     string
+	path        string
+	handler     string
+	destination string
+	time        time.Time
+}
+
+// poller Reports changes to a path by comparing snapshots of its contents
+//
+// Used for network and FUSE filesystems where inotify does not see changes
+// made by other machines.
+type poller struct {
+	root      string
+	recursive bool
+	interval  time.Duration
+	events    chan notify.EventInfo
+	stop      chan bool
+	files     map[string]fileState
+	changing  map[string]bool
+}
+
+// fileState What a snapshot records about each file
+type fileState struct {
+	size    int64
+	modtime time.Time
+	inode   uint64
+	dir     bool
+}
+
+// polled An event found by the poller
+type polled struct {
+	event notify.Event
+	path  string
 }
 
-type lockable struct {
-	sync.RWMutex
-	paths map[string]event
+// Replayed What happened to a file recorded in the journal and what would
+// happen to it under another configuration
+//
+// Before and After describe the handler and destination, for example
+// `moved to /images/a.jpg`. Changed is set when the file would be handled
+// differently and Error when it could not be replayed.
+type Replayed struct {
+	Path    string
+	Watched string
+	Before  string
+	After   string
+	Changed bool
+	Error   string
 }
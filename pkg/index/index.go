@@ -0,0 +1,466 @@
+package index
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/mproffitt/importmanager/pkg/checksum"
+	c "github.com/mproffitt/importmanager/pkg/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// FastBlockSize The number of bytes read from the start and end of a file
+// to calculate the fast hash
+const FastBlockSize = 64 * 1024
+
+// SaveInterval How often a modified index is written back to disk
+const SaveInterval = 60 * time.Second
+
+var registry *indexes = &indexes{
+	roots: make(map[string]*Index),
+}
+
+// Load Load the content index for each configured root
+//
+// Indexes are read from the cache directory if they have previously been
+// saved. Roots which are already loaded are returned as they are.
+//
+// Arguments:
+//
+// - config *config.Config The configuration to read the roots from
+//
+// Return:
+//
+// - []*Index The index for each root
+func Load(config *c.Config) (loaded []*Index) {
+	loaded = make([]*Index, 0)
+	if !config.ContentIndex.Enabled {
+		return
+	}
+
+	var dir string = filepath.Join(config.CacheDirectory, "index")
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		log.Errorf("Unable to create index directory %s - %s", dir, err.Error())
+	}
+
+	registry.Lock()
+	defer registry.Unlock()
+	for _, root := range config.IndexRoots() {
+		if _, ok := registry.roots[root]; !ok {
+			registry.roots[root] = load(root, dir)
+		}
+		loaded = append(loaded, registry.roots[root])
+	}
+	return
+}
+
+// Setup Load the content index for each root and start the periodic rescan
+func Setup(config *c.Config) {
+	for _, i := range Load(config) {
+		go i.rescan(config.ContentIndex.RescanInterval * time.Second)
+	}
+}
+
+// Lookup Find an existing file in the index with the same content as source
+//
+// Arguments:
+//
+// - source string The file to find a duplicate of
+// - dest   string The destination the file would be written to
+//
+// Return:
+//
+// - string The path to the existing file
+// - bool   True if a duplicate was found
+func Lookup(source, dest string) (string, bool) {
+	if i := forPath(dest); i != nil {
+		return i.Find(source, dest)
+	}
+	return "", false
+}
+
+// Add Record a file or directory written into an indexed root
+func Add(path string) {
+	if i := forPath(path); i != nil {
+		i.Add(path)
+	}
+}
+
+// Remove Forget a file or directory removed from an indexed root
+func Remove(path string) {
+	if i := forPath(path); i != nil {
+		i.Remove(path)
+	}
+}
+
+func forPath(path string) *Index {
+	registry.RLock()
+	defer registry.RUnlock()
+	for root, i := range registry.roots {
+		if path == root || strings.HasPrefix(path, root+string(filepath.Separator)) {
+			return i
+		}
+	}
+	return nil
+}
+
+func load(root, dir string) (i *Index) {
+	h := fnv.New64a()
+	h.Write([]byte(root))
+
+	i = &Index{
+		Root:    root,
+		Entries: make(map[string]*Entry),
+		file:    filepath.Join(dir, fmt.Sprintf("%x.json", h.Sum64())),
+	}
+
+	if data, err := ioutil.ReadFile(i.file); err == nil {
+		if err = json.Unmarshal(data, i); err != nil {
+			log.Errorf("Unable to read index for %s - %s", root, err.Error())
+		}
+	}
+	if i.Entries == nil {
+		i.Entries = make(map[string]*Entry)
+	}
+	log.Infof("Loaded content index for %s (%d entries)", root, len(i.Entries))
+	return
+}
+
+// Save Write the index to the cache directory
+func (i *Index) Save() (err error) {
+	i.Lock()
+	defer i.Unlock()
+
+	var data []byte
+	if data, err = json.Marshal(i); err != nil {
+		return
+	}
+
+	var tmp string = i.file + ".tmp"
+	if err = ioutil.WriteFile(tmp, data, 0640); err != nil {
+		return
+	}
+	if err = os.Rename(tmp, i.file); err == nil {
+		i.dirty = false
+	}
+	return
+}
+
+// Scan Walk the root updating the index to match what is on disk
+//
+// Hashes of entries whose size and modification time are unchanged are kept.
+// Files added whilst the root is walked are kept as well. If the root cannot
+// be read the index is left untouched.
+func (i *Index) Scan() (err error) {
+	log.Infof("Scanning content index for %s", i.Root)
+	var seen map[string]*Entry = make(map[string]*Entry)
+
+	err = filepath.WalkDir(i.Root, func(path string, d fs.DirEntry, e error) error {
+		if e != nil {
+			if path == i.Root {
+				return e
+			}
+			log.Warnf("Unable to index %s - %s", path, e.Error())
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		fi, e := d.Info()
+		if e != nil {
+			return nil
+		}
+		seen[path] = newEntry(path, fi)
+		return nil
+	})
+	// An unreadable root, such as an unmounted drive, keeps the index
+	// as it was
+	if err != nil {
+		return
+	}
+
+	i.Lock()
+	defer i.Unlock()
+	for path := range i.Entries {
+		if _, ok := seen[path]; ok {
+			continue
+		}
+		// Entries missed by the walk are only dropped if they are gone
+		if _, err := os.Stat(path); err != nil {
+			delete(i.Entries, path)
+		}
+	}
+	for path, e := range seen {
+		// Entries which are unchanged, or were updated after the walk
+		// saw them, are kept
+		existing, ok := i.Entries[path]
+		if ok && (existing.ModTime.After(e.ModTime) || (existing.ModTime.Equal(e.ModTime) && existing.Size == e.Size)) {
+			continue
+		}
+		i.Entries[path] = e
+	}
+	i.dirty = true
+	log.Infof("Content index for %s contains %d files", i.Root, len(i.Entries))
+	return
+}
+
+// Add Add a file, or every file under a directory, to the index
+func (i *Index) Add(path string) {
+	filepath.WalkDir(path, func(p string, d fs.DirEntry, e error) error {
+		if e != nil || !d.Type().IsRegular() {
+			return nil
+		}
+		if fi, e := d.Info(); e == nil {
+			i.Lock()
+			i.Entries[p] = newEntry(p, fi)
+			i.dirty = true
+			i.Unlock()
+		}
+		return nil
+	})
+}
+
+// Remove Remove a file, or every file under a directory, from the index
+func (i *Index) Remove(path string) {
+	i.Lock()
+	defer i.Unlock()
+	for p := range i.Entries {
+		if p == path || strings.HasPrefix(p, path+string(filepath.Separator)) {
+			delete(i.Entries, p)
+			i.dirty = true
+		}
+	}
+}
+
+// Find Find an entry with the same content as source
+//
+// Candidates are narrowed by size, then by the fast hash and finally
+// confirmed with a full sha256 comparison.
+//
+// Arguments:
+//
+// - source string The file to compare
+// - ignore string A path which should not be considered a match
+//
+// Return:
+//
+// - string The path of the matching file
+// - bool   True if a match was found
+func (i *Index) Find(source, ignore string) (string, bool) {
+	fi, err := os.Stat(source)
+	if err != nil || fi.Size() == 0 {
+		return "", false
+	}
+
+	var candidates []*Entry = i.candidates(fi.Size(), source, ignore)
+	if len(candidates) == 0 {
+		return "", false
+	}
+
+	var fast, sum string
+	if fast, err = fastHash(source, fi.Size()); err != nil {
+		return "", false
+	}
+	for _, e := range candidates {
+		if i.hash(e, false) != fast {
+			continue
+		}
+		if sum == "" {
+			if sum, err = checksum.Sum(source, checksum.SHA256); err != nil {
+				return "", false
+			}
+		}
+		if i.hash(e, true) == sum {
+			log.Infof("Content index: %s matches %s", source, e.Path)
+			return e.Path, true
+		}
+	}
+	return "", false
+}
+
+// Duplicates Find all sets of files in the index with the same content
+//
+// Files which are hard links to each other are not reported.
+func (i *Index) Duplicates() (groups [][]string) {
+	groups = make([][]string, 0)
+	var sizes map[int64][]*Entry = make(map[int64][]*Entry)
+
+	i.RLock()
+	for _, e := range i.Entries {
+		if e.Size > 0 {
+			sizes[e.Size] = append(sizes[e.Size], e)
+		}
+	}
+	i.RUnlock()
+
+	for _, entries := range sizes {
+		if len(entries) < 2 {
+			continue
+		}
+		var sums map[string][]string = make(map[string][]string)
+		for _, e := range entries {
+			if sum := i.hash(e, true); sum != "" {
+				sums[sum] = append(sums[sum], e.Path)
+			}
+		}
+		for _, paths := range sums {
+			if paths = distinctInodes(paths); len(paths) > 1 {
+				groups = append(groups, paths)
+			}
+		}
+	}
+	return
+}
+
+func (i *Index) rescan(interval time.Duration) {
+	var last time.Time
+	for {
+		if time.Since(last) >= interval {
+			if err := i.Scan(); err != nil {
+				log.Errorf("Unable to scan %s - %s", i.Root, err.Error())
+			}
+			last = time.Now()
+		}
+
+		i.RLock()
+		var dirty bool = i.dirty
+		i.RUnlock()
+		if dirty {
+			if err := i.Save(); err != nil {
+				log.Errorf("Unable to save index for %s - %s", i.Root, err.Error())
+			}
+		}
+		<-time.After(SaveInterval)
+	}
+}
+
+// candidates Get a copy of all entries of the given size which still exist
+// unchanged on disk
+func (i *Index) candidates(size int64, exclude ...string) (entries []*Entry) {
+	var excluded map[string]bool = make(map[string]bool, len(exclude))
+	for _, path := range exclude {
+		excluded[path] = true
+	}
+
+	entries = make([]*Entry, 0)
+	i.Lock()
+	defer i.Unlock()
+	for path, e := range i.Entries {
+		if e.Size != size || excluded[path] {
+			continue
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			delete(i.Entries, path)
+			i.dirty = true
+			continue
+		}
+		if fi.Size() != e.Size || !fi.ModTime().Equal(e.ModTime) {
+			// The hashes no longer apply. Refresh the entry and only
+			// keep it if it is still the right size
+			e = newEntry(path, fi)
+			i.Entries[path] = e
+			i.dirty = true
+			if e.Size != size {
+				continue
+			}
+		}
+		entries = append(entries, e)
+	}
+	return
+}
+
+// hash Get the fast or full hash of an entry, calculating it if required
+func (i *Index) hash(e *Entry, full bool) (sum string) {
+	i.RLock()
+	sum = e.Fast
+	if full {
+		sum = e.Sum
+	}
+	i.RUnlock()
+	if sum != "" {
+		return
+	}
+
+	var err error
+	if full {
+		sum, err = checksum.Sum(e.Path, checksum.SHA256)
+	} else {
+		sum, err = fastHash(e.Path, e.Size)
+	}
+	if err != nil {
+		log.Warnf("Unable to hash %s - %s", e.Path, err.Error())
+		return ""
+	}
+
+	i.Lock()
+	if full {
+		e.Sum = sum
+	} else {
+		e.Fast = sum
+	}
+	i.dirty = true
+	i.Unlock()
+	return
+}
+
+func newEntry(path string, fi os.FileInfo) *Entry {
+	return &Entry{
+		Path:    path,
+		Size:    fi.Size(),
+		ModTime: fi.ModTime(),
+	}
+}
+
+// fastHash hashes the size, first and last blocks of a file
+func fastHash(path string, size int64) (sum string, err error) {
+	var f *os.File
+	if f, err = os.Open(path); err != nil {
+		return
+	}
+	defer f.Close()
+
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%d:", size)
+	if _, err = io.CopyN(h, f, FastBlockSize); err != nil && err != io.EOF {
+		return
+	}
+	if size > 2*FastBlockSize {
+		if _, err = f.Seek(-FastBlockSize, io.SeekEnd); err != nil {
+			return
+		}
+		if _, err = io.Copy(h, f); err != nil {
+			return
+		}
+	}
+	return fmt.Sprintf("%x", h.Sum64()), nil
+}
+
+func distinctInodes(paths []string) (distinct []string) {
+	distinct = make([]string, 0)
+	var seen map[inode]bool = make(map[inode]bool)
+	for _, p := range paths {
+		fi, err := os.Stat(p)
+		if err != nil {
+			continue
+		}
+		if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+			var key inode = inode{device: uint64(st.Dev), inode: st.Ino}
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+		}
+		distinct = append(distinct, p)
+	}
+	return
+}
@@ -0,0 +1,95 @@
+package index
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// create Write a file for a test and return its information
+func create(t *testing.T, path, content string) os.FileInfo {
+	t.Helper()
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fi
+}
+
+func TestCandidatesIncludeChangedEntries(t *testing.T) {
+	var (
+		dir      string = t.TempDir()
+		existing string = filepath.Join(dir, "existing.txt")
+		source   string = filepath.Join(t.TempDir(), "source.txt")
+		i        *Index = &Index{Root: dir, Entries: make(map[string]*Entry)}
+	)
+	i.Entries[existing] = newEntry(existing, create(t, existing, "old content"))
+	i.Entries[existing].Fast = "stale"
+
+	// Same size, new content and modification time
+	create(t, existing, "new content")
+	var later time.Time = time.Now().Add(time.Minute)
+	if err := os.Chtimes(existing, later, later); err != nil {
+		t.Fatal(err)
+	}
+	create(t, source, "new content")
+
+	if match, ok := i.Find(source, ""); !ok || match != existing {
+		t.Errorf("expected %s to match the changed %s, got %q", source, existing, match)
+	}
+	if i.Entries[existing].Fast == "stale" {
+		t.Error("expected the hashes of the changed entry to be recalculated")
+	}
+}
+
+func TestScanMergesIntoTheIndex(t *testing.T) {
+	var (
+		dir     string = t.TempDir()
+		kept    string = filepath.Join(dir, "kept.txt")
+		removed string = filepath.Join(dir, "removed.txt")
+		added   string = filepath.Join(t.TempDir(), "added.txt")
+		i       *Index = &Index{Root: dir, Entries: make(map[string]*Entry)}
+	)
+	i.Entries[kept] = newEntry(kept, create(t, kept, "kept"))
+	i.Entries[kept].Sum = "kept"
+	i.Entries[removed] = newEntry(removed, create(t, removed, "removed"))
+	if err := os.Remove(removed); err != nil {
+		t.Fatal(err)
+	}
+	// Stands in for a file added whilst the root was being walked
+	i.Entries[added] = newEntry(added, create(t, added, "added"))
+
+	if err := i.Scan(); err != nil {
+		t.Fatal(err)
+	}
+	if e, ok := i.Entries[kept]; !ok || e.Sum != "kept" {
+		t.Errorf("expected the unchanged entry and its hash to be kept, got %+v", e)
+	}
+	if _, ok := i.Entries[added]; !ok {
+		t.Error("expected the entry added during the scan to be kept")
+	}
+	if _, ok := i.Entries[removed]; ok {
+		t.Error("expected the entry for a removed file to be dropped")
+	}
+}
+
+func TestScanKeepsTheIndexWhenTheRootIsMissing(t *testing.T) {
+	var (
+		dir  string = t.TempDir()
+		file string = filepath.Join(dir, "file.txt")
+		i    *Index = &Index{Root: filepath.Join(dir, "unmounted"), Entries: make(map[string]*Entry)}
+	)
+	i.Entries[file] = newEntry(file, create(t, file, "content"))
+
+	if err := i.Scan(); err == nil {
+		t.Error("expected an error scanning a missing root")
+	}
+	if len(i.Entries) != 1 {
+		t.Errorf("expected the index to be left as it was, found %d entries", len(i.Entries))
+	}
+}
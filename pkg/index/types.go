@@ -0,0 +1,37 @@
+package index
+
+import (
+	"sync"
+	"time"
+)
+
+// Entry A single file known to the index
+//
+// Hashes are calculated lazily, only when another file of the same size
+// is being compared against this one.
+type Entry struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	Fast    string    `json:"fast,omitempty"`
+	Sum     string    `json:"sha256,omitempty"`
+}
+
+// Index The content index for a single destination root
+type Index struct {
+	sync.RWMutex
+	Root    string            `json:"root"`
+	Entries map[string]*Entry `json:"entries"`
+	file    string
+	dirty   bool
+}
+
+type indexes struct {
+	sync.RWMutex
+	roots map[string]*Index
+}
+
+type inode struct {
+	device uint64
+	inode  uint64
+}
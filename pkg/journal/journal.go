@@ -0,0 +1,565 @@
+package journal
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	c "github.com/mproffitt/importmanager/pkg/config"
+	m "github.com/mproffitt/importmanager/pkg/mime"
+	log "github.com/sirupsen/logrus"
+)
+
+// FileName The name of the journal inside the state directory
+const FileName = "journal.jsonl"
+
+// CompactInterval How often finished jobs older than the retention are
+// removed from the journal whilst it is open
+const CompactInterval = time.Hour
+
+// The states a job moves through
+const (
+	// Queued The file has been seen and is waiting for a worker
+	Queued = "queued"
+
+	// Started A processor has been found and is running
+	Started = "started"
+
+	// Writing A copy is being written to a temporary file
+	Writing = "writing"
+
+	// Retrying The processor failed and will be run again
+	Retrying = "retrying"
+
+	// Interrupted The job was running when the engine stopped
+	Interrupted = "interrupted"
+
+	// Completed The processor finished without error
+	Completed = "completed"
+
+	// Failed The processor returned an error
+	Failed = "failed"
+
+	// Skipped The file was ignored or no processor matched it
+	Skipped = "skipped"
+
+	// Cancelled The file was gone before the job could be resumed
+	Cancelled = "cancelled"
+
+	// Observed The path is in observe mode. The action the processor
+	// would have taken was recorded instead of being run
+	Observed = "observed"
+)
+
+var current *Journal
+
+// Open Load the journal from the state directory and start appending to it
+//
+// Finished jobs older than retention are dropped, now and every
+// CompactInterval. Jobs which were running when the engine stopped are
+// marked as interrupted and any partially written temporary file they left
+// behind is removed.
+//
+// Arguments:
+//
+// - stateDirectory string        The directory to keep the journal in
+// - retention      time.Duration How long finished jobs are kept
+//
+// Return:
+//
+// - error Any error reading or writing the journal
+func Open(stateDirectory string, retention time.Duration) (err error) {
+	if err = os.MkdirAll(stateDirectory, 0750); err != nil {
+		return
+	}
+
+	var j *Journal = &Journal{
+		path:   filepath.Join(stateDirectory, FileName),
+		jobs:   make(map[string]*Record),
+		active: make(map[string]string),
+		stop:   make(chan bool),
+	}
+	if err = j.load(); err != nil {
+		return
+	}
+	j.recover()
+	if err = j.compact(time.Now().Add(-retention)); err != nil {
+		return
+	}
+	log.Infof("Loaded journal with %d unfinished jobs", len(j.active))
+	current = j
+	go j.maintain(retention)
+	return
+}
+
+// Close Stop writing to the journal
+func Close() {
+	if current == nil {
+		return
+	}
+	close(current.stop)
+	current.Lock()
+	defer current.Unlock()
+	current.file.Close()
+	current.file = nil
+	current = nil
+}
+
+// Queue Record that a file is waiting to be handled
+//
+// A file which already has an unfinished job keeps it so resuming the same
+// file more than once does not create duplicate jobs.
+//
+// Arguments:
+//
+// - path    string    The file being handled
+// - watched string    The watched path the file was found in
+// - event   time.Time The time of the last event for the file
+func Queue(path, watched string, event time.Time) {
+	if current == nil {
+		return
+	}
+	current.Lock()
+	defer current.Unlock()
+
+	var r *Record = current.find(path)
+	if r == nil {
+		current.sequence++
+		r = &Record{
+			ID:      fmt.Sprintf("%x-%x", time.Now().UnixNano(), current.sequence),
+			Path:    path,
+			Watched: watched,
+		}
+		current.active[path] = r.ID
+	}
+	r.Event = event
+	if fi, err := os.Stat(path); err == nil {
+		r.Size, r.ModTime = fi.Size(), fi.ModTime()
+	}
+
+	// Interrupted jobs keep their state so they can be resumed with
+	// the processor they were started with
+	var state string = Queued
+	if r.State == Interrupted {
+		state = Interrupted
+	}
+	current.write(r, state)
+}
+
+// Start Record the processor a file is being handled with
+func Start(path string, details *m.Details, processor *c.Processor) {
+	update(path, Started, func(r *Record) bool {
+		var p c.Processor = processor.Clone()
+		var d m.Details = *details
+		r.Processor, r.Details = &p, &d
+		return true
+	})
+}
+
+// Temporary Record the temporary file a copy is being written to
+//
+// Files copied as part of a directory are recorded against the job for the
+// directory. Temporary files inside the directory's own temporary copy are
+// not recorded as they are removed with it.
+func Temporary(source, temp string) {
+	apply(source, Writing, true, func(r *Record) bool {
+		if r.Temp != "" && strings.HasPrefix(temp, r.Temp+string(filepath.Separator)) {
+			return false
+		}
+		r.Temp = temp
+		return true
+	})
+}
+
+// Target Record where a file is to be written before collisions with
+// existing files are resolved
+func Target(source, target string) {
+	update(source, "", func(r *Record) bool {
+		r.Target = target
+		return true
+	})
+}
+
+// Destination Record where a file was written to
+func Destination(source, destination string) {
+	update(source, "", func(r *Record) bool {
+		r.Destination, r.Temp = destination, ""
+		return true
+	})
+}
+
+// Finish Record the result of a job
+//
+// Arguments:
+//
+// - path string The file which was handled
+// - err  error  The error returned by the processor or nil on success
+func Finish(path string, err error) {
+	update(path, Completed, func(r *Record) bool {
+		if err != nil {
+			r.State, r.Error = Failed, err.Error()
+		}
+		return true
+	})
+	forget(path)
+}
+
+// Observe Record what would have happened to a file in observe mode
+//
+// Arguments:
+//
+// - path        string The file which was observed
+// - destination string Where the file would have been written
+// - err         error  The error the processor would have failed with or nil
+func Observe(path, destination string, err error) {
+	update(path, Observed, func(r *Record) bool {
+		r.Destination = destination
+		if err != nil {
+			r.Error = err.Error()
+		}
+		return true
+	})
+	forget(path)
+}
+
+// Retry Record a failed attempt which will be retried
+func Retry(path string, err error, attempt int) {
+	update(path, Retrying, func(r *Record) bool {
+		r.Error, r.Attempt = err.Error(), attempt
+		return true
+	})
+}
+
+// Skip Record that a file was not handled
+func Skip(path, reason string) {
+	end(path, Skipped, reason)
+}
+
+// Cancel Record that a job will not be resumed
+func Cancel(path, reason string) {
+	end(path, Cancelled, reason)
+}
+
+// Pending Get the unfinished jobs for a watched path
+func Pending(watched string) (pending []Record) {
+	pending = make([]Record, 0)
+	if current == nil {
+		return
+	}
+	current.Lock()
+	defer current.Unlock()
+	for _, id := range current.active {
+		if r := current.jobs[id]; r.Watched == watched {
+			pending = append(pending, *r)
+		}
+	}
+	return
+}
+
+// History Read the latest record of each job from the journal in a state
+// directory
+//
+// The journal does not need to be open so a running instance can be
+// inspected.
+//
+// Arguments:
+//
+// - stateDirectory string    The directory the journal is kept in
+// - since          time.Time Jobs last updated before this are left out
+//
+// Return:
+//
+// - []Record The jobs, oldest first
+// - error    Any error reading the journal
+func History(stateDirectory string, since time.Time) (records []Record, err error) {
+	records = make([]Record, 0)
+	var j *Journal = &Journal{
+		path:   filepath.Join(stateDirectory, FileName),
+		jobs:   make(map[string]*Record),
+		active: make(map[string]string),
+	}
+	if err = j.load(); err != nil {
+		return
+	}
+	for _, r := range j.jobs {
+		if !r.Time.Before(since) {
+			records = append(records, *r)
+		}
+	}
+	sort.Slice(records, func(a, b int) bool {
+		return records[a].Time.Before(records[b].Time)
+	})
+	return
+}
+
+// Snapshot Get the processor an interrupted job was started with
+//
+// Return:
+//
+// - *config.Processor The processor recorded when the job started
+// - *mime.Details     The mime details recorded when the job started
+// - bool              True if the file has an interrupted job
+func Snapshot(path string) (*c.Processor, *m.Details, bool) {
+	if current == nil {
+		return nil, nil, false
+	}
+	current.Lock()
+	defer current.Unlock()
+	var r *Record = current.find(path)
+	if r == nil || r.State != Interrupted || r.Processor == nil || r.Details == nil {
+		return nil, nil, false
+	}
+	var p c.Processor = r.Processor.Clone()
+	var d m.Details = *r.Details
+	return &p, &d, true
+}
+
+// update Apply a change to the unfinished job for path and append it
+func update(path, state string, change func(r *Record) bool) {
+	apply(path, state, false, change)
+}
+
+// apply Apply a change to an unfinished job and append it
+//
+// When within is set and path has no job of its own, the job for the
+// nearest parent directory is used. This is only wanted for files copied
+// as part of a directory.
+func apply(path, state string, within bool, change func(r *Record) bool) {
+	if current == nil {
+		return
+	}
+	current.Lock()
+	defer current.Unlock()
+
+	var r *Record = current.find(path)
+	for p := filepath.Dir(path); r == nil && within; p = filepath.Dir(p) {
+		r = current.find(p)
+		if p == filepath.Dir(p) {
+			break
+		}
+	}
+	if r == nil {
+		return
+	}
+	if state == "" {
+		state = r.State
+	}
+	var previous string = r.State
+	r.State = state
+	if !change(r) {
+		r.State = previous
+		return
+	}
+	current.write(r, r.State)
+}
+
+func end(path, state, reason string) {
+	update(path, state, func(r *Record) bool {
+		r.Error = reason
+		return true
+	})
+	forget(path)
+}
+
+func forget(path string) {
+	if current == nil {
+		return
+	}
+	current.Lock()
+	defer current.Unlock()
+	delete(current.active, path)
+}
+
+// find Get the unfinished job for path. The journal must be locked
+func (j *Journal) find(path string) *Record {
+	if id, ok := j.active[path]; ok {
+		return j.jobs[id]
+	}
+	return nil
+}
+
+// write Append a record to the journal. The journal must be locked
+//
+// Finished jobs are only kept in the file. They are read back from there
+// by History and compact.
+func (j *Journal) write(r *Record, state string) {
+	r.State, r.Time = state, time.Now()
+	j.jobs[r.ID] = r
+	if !unfinished(state) {
+		delete(j.jobs, r.ID)
+		if j.active[r.Path] == r.ID {
+			delete(j.active, r.Path)
+		}
+	}
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		log.Errorf("Unable to encode journal record for %s - %s", r.Path, err.Error())
+		return
+	}
+	if _, err = j.file.Write(append(data, '\n')); err == nil {
+		err = j.file.Sync()
+	}
+	if err != nil {
+		log.Errorf("Unable to write journal record for %s - %s", r.Path, err.Error())
+	}
+}
+
+// load Read the latest record for each job
+func (j *Journal) load() (err error) {
+	var f *os.File
+	if f, err = os.Open(j.path); err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+	defer f.Close()
+
+	var (
+		scanner *bufio.Scanner = bufio.NewScanner(f)
+		line    int
+	)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line++
+		var r Record
+		if e := json.Unmarshal(scanner.Bytes(), &r); e != nil {
+			// A line cut short by a crash is expected at the end
+			log.Warnf("Skipping invalid journal record on line %d - %s", line, e.Error())
+			continue
+		}
+		j.jobs[r.ID] = &r
+	}
+	if err = scanner.Err(); err != nil {
+		return
+	}
+
+	for id, r := range j.jobs {
+		if unfinished(r.State) {
+			j.active[r.Path] = id
+		}
+	}
+	return
+}
+
+// recover Mark jobs which were running as interrupted and remove any
+// partially written files
+func (j *Journal) recover() {
+	for _, id := range j.active {
+		var r *Record = j.jobs[id]
+		if r.State != Started && r.State != Writing {
+			continue
+		}
+		log.Warnf("Job for %s was interrupted whilst %s", r.Path, r.State)
+		if r.Temp != "" {
+			if _, err := os.Lstat(r.Temp); err == nil {
+				log.Warnf("Removing partially written %s", r.Temp)
+				if err = os.RemoveAll(r.Temp); err != nil {
+					log.Errorf("Unable to remove %s - %s", r.Temp, err.Error())
+				}
+			}
+			r.Temp = ""
+		}
+		r.State, r.Time = Interrupted, time.Now()
+	}
+}
+
+// compact Rewrite the journal without finished jobs older than before
+//
+// Finished jobs are read back from the file. Unfinished jobs are written as
+// they are held in memory. The journal must be locked.
+func (j *Journal) compact(before time.Time) (err error) {
+	var (
+		history *Journal = &Journal{
+			path:   j.path,
+			jobs:   make(map[string]*Record),
+			active: make(map[string]string),
+		}
+		tmp  string = j.path + ".tmp"
+		f    *os.File
+		kept int
+	)
+	if err = history.load(); err != nil {
+		return
+	}
+	for id, r := range j.jobs {
+		history.jobs[id] = r
+	}
+	if f, err = os.OpenFile(tmp, os.O_WRONLY|os.O_APPEND|os.O_CREATE|os.O_TRUNC, 0640); err != nil {
+		return
+	}
+
+	var w *bufio.Writer = bufio.NewWriter(f)
+	for _, r := range history.jobs {
+		if !unfinished(r.State) && r.Time.Before(before) {
+			continue
+		}
+		var data []byte
+		if data, err = json.Marshal(r); err != nil {
+			f.Close()
+			return
+		}
+		w.Write(append(data, '\n'))
+		kept++
+	}
+	if err = w.Flush(); err != nil {
+		f.Close()
+		return
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return
+	}
+	if err = os.Rename(tmp, j.path); err != nil {
+		f.Close()
+		return
+	}
+
+	for id, r := range j.jobs {
+		if !unfinished(r.State) {
+			delete(j.jobs, id)
+		}
+	}
+	// The rewritten file is the journal now. Keep appending to it
+	if j.file != nil {
+		j.file.Close()
+	}
+	j.file = f
+	log.Debugf("Compacted journal to %d jobs", kept)
+	return
+}
+
+// maintain Compact the journal every CompactInterval until it is closed
+func (j *Journal) maintain(retention time.Duration) {
+	var ticker *time.Ticker = time.NewTicker(CompactInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-j.stop:
+			return
+		case <-ticker.C:
+			j.Lock()
+			// The journal may have been closed whilst waiting
+			if j.file != nil {
+				if err := j.compact(time.Now().Add(-retention)); err != nil {
+					log.Errorf("Unable to compact journal - %s", err.Error())
+				}
+			}
+			j.Unlock()
+		}
+	}
+}
+
+func unfinished(state string) bool {
+	switch strings.ToLower(state) {
+	case Queued, Started, Writing, Retrying, Interrupted:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,85 @@
+package journal
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// open Start a journal in a temporary state directory for the test
+func open(t *testing.T) string {
+	t.Helper()
+	var dir string = t.TempDir()
+	if err := Open(dir, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(Close)
+	return dir
+}
+
+func TestUpdateOnlyMatchesTheExactPath(t *testing.T) {
+	var (
+		dir       string = open(t)
+		directory string = filepath.Join(dir, "album")
+		file      string = filepath.Join(directory, "photo.jpg")
+	)
+	Queue(directory, dir, time.Now())
+
+	Target(file, "/elsewhere/photo.jpg")
+	Destination(file, "/elsewhere/photo.jpg")
+	Skip(file, "unknown type")
+	Finish(file, nil)
+
+	var pending []Record = Pending(dir)
+	if len(pending) != 1 {
+		t.Fatalf("expected the directory job to remain unfinished, found %d jobs", len(pending))
+	}
+	var r Record = pending[0]
+	if r.Path != directory || r.State != Queued {
+		t.Errorf("expected %s to be %s, got %s in state %s", directory, Queued, r.Path, r.State)
+	}
+	if r.Target != "" || r.Destination != "" || r.Error != "" {
+		t.Errorf("directory job was changed by its files: %+v", r)
+	}
+}
+
+func TestTemporaryIsRecordedAgainstTheDirectory(t *testing.T) {
+	var (
+		dir       string = open(t)
+		directory string = filepath.Join(dir, "album")
+		file      string = filepath.Join(directory, "photo.jpg")
+		temp      string = filepath.Join(dir, ".album.importmanager-partial")
+	)
+	Queue(directory, dir, time.Now())
+
+	Temporary(file, temp)
+
+	var pending []Record = Pending(dir)
+	if len(pending) != 1 {
+		t.Fatalf("expected 1 job, found %d", len(pending))
+	}
+	if pending[0].Path != directory || pending[0].Temp != temp || pending[0].State != Writing {
+		t.Errorf("expected %s to be writing to %s, got %+v", directory, temp, pending[0])
+	}
+}
+
+func TestFinishedJobsAreKeptInHistory(t *testing.T) {
+	var (
+		dir  string = open(t)
+		file string = filepath.Join(dir, "report.pdf")
+	)
+	Queue(file, dir, time.Now())
+	Destination(file, "/documents/report.pdf")
+	Finish(file, nil)
+
+	if pending := Pending(dir); len(pending) != 0 {
+		t.Errorf("expected no unfinished jobs, found %d", len(pending))
+	}
+	records, err := History(dir, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].State != Completed || records[0].Destination != "/documents/report.pdf" {
+		t.Errorf("expected a completed job for %s, got %+v", file, records)
+	}
+}
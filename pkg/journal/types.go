@@ -0,0 +1,43 @@
+package journal
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	c "github.com/mproffitt/importmanager/pkg/config"
+	m "github.com/mproffitt/importmanager/pkg/mime"
+)
+
+// Record The state of a single job at a point in time
+//
+// Each line of the journal is a complete record so the latest line for a
+// job describes it fully.
+type Record struct {
+	ID          string       `json:"id"`
+	State       string       `json:"state"`
+	Time        time.Time    `json:"time"`
+	Path        string       `json:"path"`
+	Watched     string       `json:"watched,omitempty"`
+	Event       time.Time    `json:"event"`
+	Size        int64        `json:"size,omitempty"`
+	ModTime     time.Time    `json:"mtime"`
+	Details     *m.Details   `json:"details,omitempty"`
+	Processor   *c.Processor `json:"processor,omitempty"`
+	Temp        string       `json:"temp,omitempty"`
+	Target      string       `json:"target,omitempty"`
+	Destination string       `json:"destination,omitempty"`
+	Attempt     int          `json:"attempt,omitempty"`
+	Error       string       `json:"error,omitempty"`
+}
+
+// Journal An append only log of jobs kept in the state directory
+type Journal struct {
+	sync.Mutex
+	file     *os.File
+	path     string
+	jobs     map[string]*Record
+	active   map[string]string
+	sequence uint64
+	stop     chan bool
+}
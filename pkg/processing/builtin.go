@@ -2,7 +2,6 @@ package processing
 
 import (
 	"context"
-	"crypto/sha256"
 	"fmt"
 	"io"
 	"os"
@@ -12,25 +11,45 @@ import (
 	"strings"
 
 	a "github.com/codeclysm/extract/v3"
+	"github.com/mproffitt/importmanager/pkg/checksum"
 	c "github.com/mproffitt/importmanager/pkg/config"
+	"github.com/mproffitt/importmanager/pkg/index"
+	"github.com/mproffitt/importmanager/pkg/journal"
 	m "github.com/mproffitt/importmanager/pkg/mime"
 	log "github.com/sirupsen/logrus"
 )
 
+// PartialSuffix Added to the name of files and directories whilst they are
+// being copied
+const PartialSuffix = ".importmanager-partial"
+
 func pcopy(source, dest string, details *m.Details, processor *c.Processor) (final string, err error) {
 	var _, basename, extension = m.SplitPathByMime(source)
+	var suffix string = extension
 	final = dest
-	if b, _ := strconv.ParseBool(processor.Properties["strip-extension"]); !b {
-		basename = basename + extension
+	if b, _ := strconv.ParseBool(processor.Properties["strip-extension"]); b {
+		suffix = ""
 	}
 
 	if b, _ := strconv.ParseBool(processor.Properties["lowercase-destination"]); b {
-		basename = strings.ToLower(basename)
+		basename, suffix = strings.ToLower(basename), strings.ToLower(suffix)
 	}
 
 	// If destination looks like a filename, we keep that.
 	if !strings.EqualFold(path.Ext(dest), extension) {
-		final = filepath.Join(dest, basename)
+		if b, _ := strconv.ParseBool(processor.Properties["collapse-duplicates"]); b {
+			var discard bool
+			if basename, discard = collapseDuplicate(source, dest, basename, suffix, processor); discard {
+				if _, err = pdelete(source); err != nil {
+					return
+				}
+				err = fmt.Errorf("copy-deleted")
+				return
+			}
+		} else {
+			basename = sanitise(basename, suffix, processor)
+		}
+		final = filepath.Join(dest, resolveCollision(dest, basename, processor))
 	}
 
 	log.Infof("Copy: Testing final %s", final)
@@ -47,28 +66,65 @@ func pcopy(source, dest string, details *m.Details, processor *c.Processor) (fin
 		return
 	}
 
+	var handled bool
+	if handled, err = dedupe(source, final, processor); handled || err != nil {
+		return
+	}
+
 	log.Info("Using standard copy (not comparing sha)")
+	if err = copyFile(source, final, processor); err != nil {
+		return
+	}
+	index.Add(final)
+	return
+}
+
+// copyFile Copy the content and attributes of source to final
+//
+// The copy is written to a temporary file next to final which is renamed
+// once complete, so an interrupted copy never leaves a partial file under
+// the final name.
+func copyFile(source, final string, processor *c.Processor) (err error) {
 	var (
-		r *os.File
-		w *os.File
+		r   *os.File
+		w   *os.File
+		fi  os.FileInfo
+		tmp string = partialName(final)
 	)
+	if fi, err = os.Stat(source); err != nil {
+		return
+	}
 	if r, err = os.Open(source); err != nil {
 		return
 	}
 	defer r.Close()
 
-	if w, err = os.Create(final); err != nil {
+	journal.Temporary(source, tmp)
+	if w, err = os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, createMode(source, processor)); err != nil {
 		return
 	}
-
 	defer func() {
-		if err = w.Close(); err != nil {
-			return
+		if err != nil {
+			os.Remove(tmp)
 		}
 	}()
 
-	_, err = io.Copy(w, r)
-	return
+	if _, err = io.Copy(w, r); err != nil {
+		w.Close()
+		return
+	}
+	if err = w.Close(); err != nil {
+		return
+	}
+	if err = preserveAttributes(source, fi, tmp, processor); err != nil {
+		return
+	}
+	return os.Rename(tmp, final)
+}
+
+// partialName The name of the temporary file used whilst writing path
+func partialName(path string) string {
+	return filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+PartialSuffix)
 }
 
 func pmove(source, dest string, details *m.Details, processor *c.Processor) (final string, err error) {
@@ -86,6 +142,7 @@ func pmove(source, dest string, details *m.Details, processor *c.Processor) (fin
 func pextract(source, dest string, details *m.Details, processor *c.Processor) (final string, err error) {
 	var (
 		file     *os.File
+		fi       os.FileInfo
 		basename string = path.Base(source)
 	)
 	log.Debugf("Stripping extension '%s'", details.Extension)
@@ -93,8 +150,12 @@ func pextract(source, dest string, details *m.Details, processor *c.Processor) (
 	if strings.HasSuffix(basename, ".tar") {
 		basename = strings.TrimSuffix(basename, ".tar")
 	}
-	final = filepath.Join(dest, basename)
+	basename = sanitise(basename, "", processor)
+	final = filepath.Join(dest, resolveCollision(dest, basename, processor))
 
+	if fi, err = os.Stat(source); err != nil {
+		return
+	}
 	if file, err = os.Open(source); err != nil {
 		return
 	}
@@ -105,6 +166,13 @@ func pextract(source, dest string, details *m.Details, processor *c.Processor) (
 		return
 	}
 
+	// Modes are taken from the archive entries, times and attributes
+	// from the archive itself are applied to the extraction directory
+	if err = preserveAttributes(source, fi, final, processor); err != nil {
+		return
+	}
+	index.Add(final)
+
 	for k, v := range processor.Properties {
 		switch k {
 		case "cleanup-source":
@@ -121,26 +189,28 @@ func pextract(source, dest string, details *m.Details, processor *c.Processor) (
 func pinstall(source, dest string, details *m.Details, processor *c.Processor) (final string, err error) {
 	// To protect the overall system, we only "install" AppImages and scripts which are
 	// "installed" by moving them to ~/bin and setting the executable flag
-	if final, err = pmove(source, dest, details, processor); err == nil {
-		// this is handled by the post processor
-		(*processor).Properties["setexec"] = final
+	if final, err = pmove(source, dest, details, processor); err != nil {
+		return
 	}
+	err = setExecutable(final)
 	return
 }
 
 func pdelete(source string) (final string, err error) {
 	log.Infof("Deleting path '%s'.", source)
-	err = os.Remove(source)
+	if err = os.Remove(source); err == nil {
+		index.Remove(source)
+	}
 	return
 }
 
 // This is a much slower operation so should be used sparingly
 //
-// If sha256 matches on both files, delete the source
-// If Sha256 does not match, add 1 to the filename and retest until we find a unique filename
+// If the checksums match on both files, delete the source
+// If the checksums do not match, add 1 to the filename and retest until we find a unique filename
 func copyWithShaCheck(source, dest string, details *m.Details, processor *c.Processor) (final string, err error) {
 	log.Infof("Using `copyWithShaCheck` for %s", source)
-	if final, err = cleanupIfEqual(source, dest); err != nil {
+	if final, err = cleanupIfEqual(source, dest, processor); err != nil {
 		return
 	}
 	var (
@@ -153,7 +223,7 @@ func copyWithShaCheck(source, dest string, details *m.Details, processor *c.Proc
 		filename = fmt.Sprintf("%s%s_%d%s", dirname, basename, i, extension)
 		log.Infof("Using filename %s for sha test", filename)
 		if _, err = os.Stat(filename); err == nil {
-			if final, err = cleanupIfEqual(source, filename); err != nil {
+			if final, err = cleanupIfEqual(source, filename, processor); err != nil {
 				return
 			}
 			i++
@@ -161,38 +231,34 @@ func copyWithShaCheck(source, dest string, details *m.Details, processor *c.Proc
 		}
 		break
 	}
-	(*processor).Properties["compare-sha"] = "false"
-	final, err = pcopy(source, filename, details, processor)
+	// The new name is known to be free so copy without comparing again
+	var q c.Processor = processor.Clone()
+	q.Properties["compare-sha"] = "false"
+	final, err = pcopy(source, filename, details, &q)
 	return
 }
 
-func cleanupIfEqual(source, dest string) (string, error) {
-	if sha256Equal(source, dest) {
+func cleanupIfEqual(source, dest string, processor *c.Processor) (string, error) {
+	if contentEqual(source, dest, processor) {
 		pdelete(source)
-		return dest, fmt.Errorf("sha256-match %s - source deleted", source)
+		return dest, fmt.Errorf("checksum-match %s - source deleted", source)
 	}
 	return dest, nil
 }
 
-func sha256Equal(source, dest string) bool {
-	log.Infof("Comparing sha256 between %s and %s", source, dest)
-	var a, b string = getSha256(source), getSha256(dest)
-	return a == b
-}
-
-func getSha256(filename string) string {
-	f, err := os.Open(filename)
-	if err != nil {
-		log.Error(err)
-		return ""
-	}
-	defer f.Close()
-
-	h := sha256.New()
-	if _, err := io.Copy(h, f); err != nil {
-		log.Error(err)
-		return ""
+// IsHandled Test if an error from Process means the source was dealt with
+// rather than the processor failing
+//
+// This is the case when the source was removed as a duplicate of an
+// existing file.
+func IsHandled(err error) bool {
+	if err == nil {
+		return false
 	}
+	return err.Error() == "copy-deleted" || strings.HasPrefix(err.Error(), "checksum-match")
+}
 
-	return fmt.Sprintf("%x", h.Sum(nil))
+// contentEqual Compare two files using the processor's `hash-algorithm`
+func contentEqual(source, dest string, processor *c.Processor) bool {
+	return checksum.Equal(source, dest, processor.Properties["hash-algorithm"])
 }
@@ -0,0 +1,66 @@
+package processing
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	c "github.com/mproffitt/importmanager/pkg/config"
+	"github.com/mproffitt/importmanager/pkg/index"
+	log "github.com/sirupsen/logrus"
+)
+
+// dedupe Apply the `dedupe` property using the content index
+//
+// Arguments:
+//
+// - source    string     The file being imported
+// - final     string     The destination the file would be written to
+// - processor *Processor The processor being executed
+//
+// Return:
+//
+// - bool  True if the file has been handled and should not be copied
+// - error `copy-deleted` if the source was removed as a duplicate
+func dedupe(source, final string, processor *c.Processor) (handled bool, err error) {
+	var strategy string = strings.ToLower(processor.Properties["dedupe"])
+	if strategy == "" {
+		return
+	}
+
+	var match, ok = index.Lookup(source, final)
+	if !ok {
+		return
+	}
+
+	switch strategy {
+	case "delete":
+		log.Warnf("'%s' is a duplicate of '%s'. Removing source", source, match)
+		if _, err = pdelete(source); err == nil {
+			err = fmt.Errorf("copy-deleted")
+		}
+		handled = true
+	case "hardlink":
+		if err = os.Link(match, final); err != nil {
+			log.Warnf("Unable to link '%s' to '%s' - %s. Copying instead", final, match, err.Error())
+			err = nil
+			return
+		}
+		log.Infof("'%s' is a duplicate of '%s'. Created hard link '%s'", source, match, final)
+		index.Add(final)
+		handled = true
+	case "symlink":
+		if err = os.Symlink(match, final); err != nil {
+			log.Warnf("Unable to symlink '%s' to '%s' - %s. Copying instead", final, match, err.Error())
+			err = nil
+			return
+		}
+		log.Infof("'%s' is a duplicate of '%s'. Created symbolic link '%s'", source, match, final)
+		handled = true
+	case "report":
+		log.Warnf("'%s' is a duplicate of existing file '%s'", source, match)
+	default:
+		log.Errorf("Unknown dedupe strategy '%s'", strategy)
+	}
+	return
+}
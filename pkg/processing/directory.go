@@ -0,0 +1,234 @@
+package processing
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	c "github.com/mproffitt/importmanager/pkg/config"
+	"github.com/mproffitt/importmanager/pkg/index"
+	"github.com/mproffitt/importmanager/pkg/journal"
+	m "github.com/mproffitt/importmanager/pkg/mime"
+	log "github.com/sirupsen/logrus"
+)
+
+// builtInDirectory Run a builtin handler against a directory
+//
+// Only `copy`, `move` and `delete` can be used with directories.
+func builtInDirectory(source, dest string, processor *c.Processor) (final string, err error) {
+	switch processor.Handler {
+	case "copy":
+		final, err = copyDirectory(source, dest, processor)
+	case "move":
+		final, err = moveDirectory(source, dest, processor)
+	case "delete":
+		log.Infof("Deleting directory '%s'.", source)
+		if err = os.RemoveAll(source); err == nil {
+			index.Remove(source)
+		}
+	default:
+		err = fmt.Errorf("handler '%s' does not support directories", processor.Handler)
+	}
+	return
+}
+
+// copyDirectory Copy a directory tree into dest
+//
+// The directory keeps its own name. If a file or directory of that name
+// already exists in dest, `_1`, `_2`, ... is appended until the name is unique.
+// Symlinks are recreated rather than followed.
+//
+// Arguments:
+//
+// - source    string     The directory to copy
+// - dest      string     The directory to copy into
+// - processor *Processor The processor being executed
+//
+// Return:
+//
+// - final string The path of the new directory
+// - err   error  Any error encountered whilst copying
+func copyDirectory(source, dest string, processor *c.Processor) (final string, err error) {
+	if final, err = directoryTarget(source, dest, processor); err != nil {
+		return
+	}
+	log.Infof("Copying directory '%s' to '%s'", source, final)
+
+	// The tree is built under a temporary name and renamed once complete
+	var (
+		tmp   string        = partialName(final)
+		dirs  []string      = make([]string, 0)
+		infos []os.FileInfo = make([]os.FileInfo, 0)
+	)
+	journal.Temporary(source, tmp)
+	defer func() {
+		if err != nil {
+			os.RemoveAll(tmp)
+		}
+	}()
+
+	err = filepath.WalkDir(source, func(path string, d fs.DirEntry, e error) error {
+		if e != nil {
+			return e
+		}
+
+		var target string = tmp
+		if path != source {
+			rel, _ := filepath.Rel(source, path)
+			target = filepath.Join(tmp, sanitiseRelative(rel, d.IsDir(), processor))
+		}
+
+		switch {
+		case d.IsDir():
+			// Taken before the directory is read so its access
+			// time is the original
+			fi, e := d.Info()
+			if e != nil {
+				return e
+			}
+			dirs = append(dirs, path, target)
+			infos = append(infos, fi)
+			return os.MkdirAll(target, createMode(path, processor)|0700)
+		case d.Type()&fs.ModeSymlink != 0:
+			link, e := os.Readlink(path)
+			if e != nil {
+				return e
+			}
+			return os.Symlink(link, target)
+		case d.Type().IsRegular():
+			return copyFile(path, target, processor)
+		}
+		log.Warnf("Skipping special file '%s'", path)
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	// Writing the contents updates the modification time of each
+	// directory so attributes are applied deepest first once all
+	// files are in place
+	for i := len(dirs) - 2; i >= 0; i -= 2 {
+		if err = preserveAttributes(dirs[i], infos[i/2], dirs[i+1], processor); err != nil {
+			return
+		}
+	}
+	if err = os.Rename(tmp, final); err != nil {
+		return
+	}
+	index.Add(final)
+	return
+}
+
+// moveDirectory Move a directory tree into dest
+//
+// A rename is attempted first. When source and dest are on different
+// filesystems, or the contents need sanitising, the tree is copied and the
+// source removed.
+func moveDirectory(source, dest string, processor *c.Processor) (final string, err error) {
+	if final, err = directoryTarget(source, dest, processor); err != nil {
+		return
+	}
+
+	log.Infof("Moving directory '%s' to '%s'", source, final)
+	if _, sanitised := getProfile(processor); !sanitised {
+		if err = os.Rename(source, final); err == nil {
+			index.Remove(source)
+			index.Add(final)
+			return
+		}
+		log.Debugf("Unable to rename '%s' - %s. Falling back to copy", source, err.Error())
+	}
+
+	if final, err = copyDirectory(source, dest, processor); err != nil {
+		return
+	}
+	if err = os.RemoveAll(source); err == nil {
+		index.Remove(source)
+	}
+	return
+}
+
+// Relocate Move a file or directory into dir keeping its name
+//
+// A number is appended to the name if it is already taken in dir.
+//
+// Arguments:
+//
+// - source string The file or directory to move
+// - dir    string The directory to move it into
+//
+// Return:
+//
+// - final string The new path of the file or directory
+// - err   error  Any error encountered whilst moving
+func Relocate(source, dir string) (final string, err error) {
+	var processor *c.Processor = &c.Processor{Properties: make(map[string]string)}
+	if err = os.MkdirAll(dir, 0750); err != nil {
+		return
+	}
+	if isDir(source) {
+		return moveDirectory(source, dir, processor)
+	}
+
+	if final, err = directoryTarget(source, dir, processor); err != nil {
+		return
+	}
+	if err = os.Rename(source, final); err == nil {
+		return
+	}
+	if err = copyFile(source, final, processor); err != nil {
+		return
+	}
+	err = os.Remove(source)
+	return
+}
+
+// directoryTarget Find a unique, sanitised name for a file or directory inside dest
+func directoryTarget(source, dest string, processor *c.Processor) (final string, err error) {
+	var (
+		name     string = sanitise(filepath.Base(source), "", processor)
+		resolved string = resolveCollision(dest, name, processor)
+	)
+	final = filepath.Join(dest, resolved)
+
+	if final == source || strings.HasPrefix(dest, source+string(filepath.Separator)) {
+		err = fmt.Errorf("cannot copy directory '%s' into itself", source)
+		return
+	}
+
+	// Numbers go before the extension of files so the name still matches
+	// their type
+	var basename, extension string = resolved, ""
+	if !isDir(source) {
+		if _, basename, extension = m.SplitPathByMime(resolved); extension == "" {
+			extension = filepath.Ext(resolved)
+			basename = strings.TrimSuffix(resolved, extension)
+		}
+		if basename == "" {
+			basename, extension = resolved, ""
+		}
+	}
+	for i := 1; ; i++ {
+		if _, e := os.Lstat(final); e != nil {
+			break
+		}
+		final = filepath.Join(dest, fmt.Sprintf("%s_%d%s", basename, i, extension))
+	}
+	return
+}
+
+// sanitiseRelative Sanitise each component of a path relative to a directory
+func sanitiseRelative(rel string, isDir bool, processor *c.Processor) string {
+	var parts []string = strings.Split(rel, string(filepath.Separator))
+	for i, part := range parts {
+		var ext string
+		if i == len(parts)-1 && !isDir {
+			ext = filepath.Ext(part)
+		}
+		parts[i] = sanitise(strings.TrimSuffix(part, ext), ext, processor)
+	}
+	return filepath.Join(parts...)
+}
@@ -0,0 +1,59 @@
+package processing
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	c "github.com/mproffitt/importmanager/pkg/config"
+)
+
+func TestDirectoryTarget(t *testing.T) {
+	var tests = []struct {
+		name      string
+		source    string
+		directory bool
+		expected  string
+	}{
+		{name: "number goes before the extension", source: "report.pdf", expected: "report_1.pdf"},
+		{name: "dotfile without an extension", source: ".bashrc", expected: ".bashrc_1"},
+		{name: "file without an extension", source: "README", expected: "README_1"},
+		{name: "directory keeps dots in its name", source: "photos.2023", directory: true, expected: "photos.2023_1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				source    string       = filepath.Join(t.TempDir(), tt.source)
+				dest      string       = t.TempDir()
+				processor *c.Processor = &c.Processor{Properties: map[string]string{}}
+			)
+			for _, path := range []string{source, filepath.Join(dest, tt.source)} {
+				if tt.directory {
+					if err := os.Mkdir(path, 0750); err != nil {
+						t.Fatal(err)
+					}
+					continue
+				}
+				if err := ioutil.WriteFile(path, []byte("content"), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			final, err := directoryTarget(source, dest, processor)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := filepath.Base(final); got != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestDirectoryTargetRejectsCopyIntoItself(t *testing.T) {
+	var source string = t.TempDir()
+	if _, err := directoryTarget(source, filepath.Join(source, "nested"), &c.Processor{Properties: map[string]string{}}); err == nil {
+		t.Error("expected an error when the destination is inside the source")
+	}
+}
@@ -0,0 +1,91 @@
+package processing
+
+import (
+	"os"
+	"path/filepath"
+	re "regexp"
+
+	c "github.com/mproffitt/importmanager/pkg/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// Suffixes added by browsers when the same file is downloaded more than once
+// e.g. `file (1).pdf` or `file(2).pdf`
+var duplicateSuffix *re.Regexp = re.MustCompile(` ?\(\d+\)$`)
+
+// Suffixes some browsers add such as `file-3.pdf`. These are common in
+// ordinary names like `scan-001.pdf` so are only stripped when a file with
+// the base name already exists
+var numberedSuffix *re.Regexp = re.MustCompile(`-\d{1,3}$`)
+
+// stripDuplicateSuffix Remove a browser duplicate suffix from basename
+//
+// Return:
+//
+// - string The basename without the suffix
+// - bool   True if the suffix may be part of an ordinary name
+// - bool   True if a suffix was found
+func stripDuplicateSuffix(basename string) (string, bool, bool) {
+	for _, suffix := range []*re.Regexp{duplicateSuffix, numberedSuffix} {
+		var stripped string = suffix.ReplaceAllString(basename, "")
+		if stripped != "" && stripped != basename {
+			return stripped, suffix == numberedSuffix, true
+		}
+	}
+	return basename, false, false
+}
+
+// collapseDuplicate Find the name a re-downloaded file should be stored as
+//
+// If the file has a browser duplicate suffix the destination is computed from
+// the base name instead. When a file with matching content already exists under
+// that name the re-download is flagged for discarding. If the content differs,
+// the original name is kept and the usual collision handling applies. A `-N`
+// suffix is only stripped when a file with the base name exists.
+//
+// Arguments:
+//
+// - source    string     The path to the incoming file
+// - dest      string     The destination directory
+// - basename  string     The filename without extension
+// - suffix    string     The extension to use for the destination
+// - processor *Processor The processor being executed
+//
+// Return:
+//
+// - name    string The filename to store the file under
+// - discard bool   True if the file is a duplicate of an existing file
+func collapseDuplicate(source, dest, basename, suffix string, processor *c.Processor) (name string, discard bool) {
+	name = sanitise(basename, suffix, processor)
+
+	var (
+		stripped  string
+		ambiguous bool
+		ok        bool
+	)
+	if stripped, ambiguous, ok = stripDuplicateSuffix(basename); !ok {
+		return
+	}
+
+	var original string = resolveCollision(dest, sanitise(stripped, suffix, processor), processor)
+	log.Infof("Detected duplicate suffix on '%s'. Base name is '%s'", source, original)
+
+	if _, err := os.Stat(filepath.Join(dest, original)); err != nil {
+		if ambiguous {
+			log.Infof("No file named '%s' exists. Keeping '%s' as '%s'", original, source, name)
+			return
+		}
+		log.Infof("Storing '%s' as '%s'", source, original)
+		name = original
+		return
+	}
+
+	if contentEqual(source, filepath.Join(dest, original), processor) {
+		log.Warnf("'%s' is a re-download of '%s'. Discarding", source, filepath.Join(dest, original))
+		discard = true
+		return
+	}
+
+	log.Infof("Content of '%s' differs from '%s'. Keeping as '%s'", source, original, name)
+	return
+}
@@ -0,0 +1,73 @@
+package processing
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	c "github.com/mproffitt/importmanager/pkg/config"
+)
+
+func TestStripDuplicateSuffix(t *testing.T) {
+	var tests = []struct {
+		basename  string
+		stripped  string
+		ambiguous bool
+		found     bool
+	}{
+		{basename: "file (1)", stripped: "file", found: true},
+		{basename: "file(12)", stripped: "file", found: true},
+		{basename: "invoice-2", stripped: "invoice", ambiguous: true, found: true},
+		{basename: "scan-001", stripped: "scan", ambiguous: true, found: true},
+		{basename: "report-2023", stripped: "report-2023"},
+		{basename: "(1)", stripped: "(1)"},
+		{basename: "plain", stripped: "plain"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.basename, func(t *testing.T) {
+			stripped, ambiguous, found := stripDuplicateSuffix(tt.basename)
+			if stripped != tt.stripped || ambiguous != tt.ambiguous || found != tt.found {
+				t.Errorf("expected (%q, %t, %t), got (%q, %t, %t)", tt.stripped, tt.ambiguous, tt.found, stripped, ambiguous, found)
+			}
+		})
+	}
+}
+
+func TestCollapseDuplicate(t *testing.T) {
+	var tests = []struct {
+		name     string
+		existing map[string]string
+		basename string
+		content  string
+		expected string
+		discard  bool
+	}{
+		{name: "browser suffix without an original", basename: "file (1)", content: "a", expected: "file.pdf"},
+		{name: "browser suffix with the same content", existing: map[string]string{"file.pdf": "a"}, basename: "file (1)", content: "a", expected: "file (1).pdf", discard: true},
+		{name: "browser suffix with different content", existing: map[string]string{"file.pdf": "b"}, basename: "file (1)", content: "a", expected: "file (1).pdf"},
+		{name: "numbered name without an original", basename: "scan-001", content: "a", expected: "scan-001.pdf"},
+		{name: "numbered name with the same content", existing: map[string]string{"invoice.pdf": "a"}, basename: "invoice-2", content: "a", expected: "invoice-2.pdf", discard: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				dest      string       = t.TempDir()
+				source    string       = filepath.Join(t.TempDir(), tt.basename+".pdf")
+				processor *c.Processor = &c.Processor{Properties: map[string]string{}}
+			)
+			for name, content := range tt.existing {
+				if err := ioutil.WriteFile(filepath.Join(dest, name), []byte(content), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := ioutil.WriteFile(source, []byte(tt.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			name, discard := collapseDuplicate(source, dest, tt.basename, ".pdf", processor)
+			if name != tt.expected || discard != tt.discard {
+				t.Errorf("expected (%q, %t), got (%q, %t)", tt.expected, tt.discard, name, discard)
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package processing
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	c "github.com/mproffitt/importmanager/pkg/config"
+	m "github.com/mproffitt/importmanager/pkg/mime"
+	log "github.com/sirupsen/logrus"
+)
+
+// Plan Work out what a processor would do with a file without doing it
+//
+// The destination is rendered from the processor's path template and the
+// plugin, if any, is checked to exist and be runnable. Nothing is written.
+// Collisions with existing files are only resolved when the file is handled
+// so the destination given is where the file would be written if there were
+// none.
+//
+// Arguments:
+//
+// - source    string           The file to process
+// - root      string           The watched path the file was found in
+// - details   *mime.Details    Mime information about the file
+// - processor *config.Processor The processor which would be executed
+//
+// Return:
+//
+// - string The file or directory which would be written. Empty for `delete`
+// - error  Any error rendering the destination or finding the plugin
+func Plan(source, root string, details *m.Details, processor *c.Processor) (destination string, err error) {
+	return PlanFrom(source, source, root, details, processor)
+}
+
+// PlanFrom Work out what a processor would have done with a file which has
+// since been moved
+//
+// This is Plan with dates and exif data read from where the file is now.
+//
+// Arguments:
+//
+// - source    string           The file as it was found
+// - from      string           Where the file is now
+// - root      string           The watched path the file was found in
+// - details   *mime.Details    Mime information about the file
+// - processor *config.Processor The processor which would be executed
+//
+// Return:
+//
+// - string The file or directory which would be written. Empty for `delete`
+// - error  Any error rendering the destination or finding the plugin
+func PlanFrom(source, from, root string, details *m.Details, processor *c.Processor) (destination string, err error) {
+	var q c.Processor = processor.Clone()
+	if strings.EqualFold(q.Handler, "delete") {
+		return
+	}
+
+	var dest string
+	if dest, err = render(source, from, root, q.Path, details, &q); err != nil {
+		return
+	}
+
+	if !c.DefaultHandlers.IsBuiltIn(q.Handler) {
+		if _, err = os.Stat(q.Handler); err != nil {
+			err = fmt.Errorf("Plugin %s cannot be found. %s", q.Handler, err)
+			return
+		}
+		if _, err = interpreter(q.Handler); err != nil {
+			return
+		}
+	}
+
+	destination = target(source, from, dest, details, &q)
+	log.Debugf("Planned destination for %s is %s", source, destination)
+	return
+}
+
+// target Where a processor writes a file in the rendered directory dest
+// before collisions with existing files are resolved
+func target(source, from, dest string, details *m.Details, processor *c.Processor) string {
+	switch {
+	case strings.EqualFold(processor.Handler, "delete"):
+		return ""
+	case !c.DefaultHandlers.IsBuiltIn(processor.Handler):
+		// Plugins decide their own filename
+		return dest
+	}
+	return filepath.Join(dest, plannedName(source, from, dest, details, processor))
+}
+
+// plannedName The name a built-in handler would give a file in dest
+func plannedName(source, from, dest string, details *m.Details, processor *c.Processor) string {
+	if isDir(from) {
+		return sanitise(filepath.Base(source), "", processor)
+	}
+	if strings.EqualFold(processor.Handler, "extract") {
+		var basename string = strings.TrimSuffix(filepath.Base(source), details.Extension)
+		return sanitise(strings.TrimSuffix(basename, ".tar"), "", processor)
+	}
+
+	var _, basename, extension = m.SplitPathByMime(source)
+	var suffix string = extension
+	// If destination looks like a filename, we keep that.
+	if strings.EqualFold(path.Ext(dest), extension) {
+		return ""
+	}
+	if b, _ := strconv.ParseBool(processor.Properties["strip-extension"]); b {
+		suffix = ""
+	}
+	if b, _ := strconv.ParseBool(processor.Properties["lowercase-destination"]); b {
+		basename, suffix = strings.ToLower(basename), strings.ToLower(suffix)
+	}
+	return sanitise(basename, suffix, processor)
+}
@@ -45,15 +45,7 @@ func runPlugin(source, dest string, details *m.Details, processor *c.Processor)
 		response   []byte
 	)
 
-	switch strings.ToLower(filepath.Ext(processor.Handler)) {
-	case ".py":
-		executable = "python"
-	case ".sh":
-		executable = "sh"
-	case ".bash":
-		executable = "bash"
-	default:
-		err = fmt.Errorf("Invalid plugin filetype")
+	if executable, err = interpreter(processor.Handler); err != nil {
 		return
 	}
 
@@ -88,3 +80,18 @@ func runPlugin(source, dest string, details *m.Details, processor *c.Processor)
 	log.Infof("Using '%s' as final destination", final)
 	return
 }
+
+// interpreter Get the command a plugin is run with from its extension
+func interpreter(plugin string) (executable string, err error) {
+	switch strings.ToLower(filepath.Ext(plugin)) {
+	case ".py":
+		executable = "python"
+	case ".sh":
+		executable = "sh"
+	case ".bash":
+		executable = "bash"
+	default:
+		err = fmt.Errorf("Invalid plugin filetype")
+	}
+	return
+}
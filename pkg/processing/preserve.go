@@ -0,0 +1,152 @@
+package processing
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	c "github.com/mproffitt/importmanager/pkg/config"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// Extended attributes holding POSIX ACLs. These are copied when
+// `preserve-acls` is enabled.
+var aclAttributes = map[string]bool{
+	"system.posix_acl_access":  true,
+	"system.posix_acl_default": true,
+}
+
+// enabled Test a boolean property which defaults to true when missing
+// or unparsable.
+func enabled(processor *c.Processor, property string) bool {
+	if v, ok := processor.Properties[property]; ok {
+		if b, err := strconv.ParseBool(v); err == nil {
+			return b
+		}
+	}
+	return true
+}
+
+// createMode Get the mode a new copy of source should be created with.
+//
+// When `preserve-mode` is enabled this is the permission bits of the source,
+// otherwise the default for a new file. The process umask is applied by the
+// kernel on creation.
+func createMode(source string, processor *c.Processor) os.FileMode {
+	var mode os.FileMode = 0666
+	if fi, err := os.Stat(source); err == nil {
+		if fi.IsDir() {
+			mode = 0777
+		}
+		if enabled(processor, "preserve-mode") {
+			mode = fi.Mode().Perm()
+		}
+	}
+	return mode
+}
+
+// preserveAttributes Copy attributes from source onto dest.
+//
+// Attributes are applied in the order extended attributes, mode and
+// finally times as writing xattrs and ACLs may otherwise alter the others.
+//
+// Mode is only applied when source and dest are of the same kind (file or
+// directory). This allows archive attributes to be applied to the directory
+// they are extracted into.
+//
+// sfi must be taken before source is read as reading it updates the access
+// time.
+func preserveAttributes(source string, sfi os.FileInfo, dest string, processor *c.Processor) (err error) {
+	var dfi os.FileInfo
+	if dfi, err = os.Stat(dest); err != nil {
+		return
+	}
+
+	copyXattrs(source, dest, enabled(processor, "preserve-xattrs"), enabled(processor, "preserve-acls"))
+
+	if v, ok := processor.Properties["umask"]; ok && sfi.IsDir() == dfi.IsDir() {
+		var mask uint64
+		if mask, err = strconv.ParseUint(v, 8, 32); err != nil {
+			return
+		}
+		var mode os.FileMode = createMode(source, processor) &^ os.FileMode(mask)
+		log.Debugf("Setting mode %#o on %s", mode, dest)
+		if err = os.Chmod(dest, mode); err != nil {
+			return
+		}
+	}
+
+	if enabled(processor, "preserve-times") {
+		var atime time.Time = sfi.ModTime()
+		if st, ok := sfi.Sys().(*syscall.Stat_t); ok {
+			atime = time.Unix(st.Atim.Sec, st.Atim.Nsec)
+		}
+		log.Debugf("Setting times on %s", dest)
+		err = os.Chtimes(dest, atime, sfi.ModTime())
+	}
+	return
+}
+
+// copyXattrs copies user extended attributes and / or ACLs from source to dest
+//
+// Failures are logged rather than returned as many destination filesystems
+// (FAT, exFAT, some network shares) do not support extended attributes and
+// this should not prevent the file from being handled.
+func copyXattrs(source, dest string, xattrs, acls bool) {
+	if !xattrs && !acls {
+		return
+	}
+
+	var names []string
+	if names = listXattrs(source); len(names) == 0 {
+		return
+	}
+
+	for _, name := range names {
+		if !(xattrs && strings.HasPrefix(name, "user.")) && !(acls && aclAttributes[name]) {
+			continue
+		}
+
+		var (
+			value []byte
+			size  int
+			err   error
+		)
+		if size, err = unix.Lgetxattr(source, name, nil); err != nil {
+			log.Warnf("Unable to read attribute %s from %s - %s", name, source, err.Error())
+			continue
+		}
+		value = make([]byte, size)
+		if size, err = unix.Lgetxattr(source, name, value); err != nil {
+			log.Warnf("Unable to read attribute %s from %s - %s", name, source, err.Error())
+			continue
+		}
+		if err = unix.Lsetxattr(dest, name, value[:size], 0); err != nil {
+			log.Warnf("Unable to set attribute %s on %s - %s", name, dest, err.Error())
+		}
+	}
+}
+
+func listXattrs(path string) (names []string) {
+	var (
+		size int
+		err  error
+		buf  []byte
+	)
+	if size, err = unix.Llistxattr(path, nil); err != nil || size == 0 {
+		return
+	}
+	buf = make([]byte, size)
+	if size, err = unix.Llistxattr(path, buf); err != nil {
+		return
+	}
+	for _, name := range strings.Split(string(buf[:size]), "\x00") {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return
+}
@@ -2,7 +2,7 @@ package processing
 
 import (
 	"bytes"
-	"html/template"
+	"fmt"
 	"io/fs"
 	"os"
 	"os/user"
@@ -10,36 +10,32 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"text/template"
 	"time"
 
 	exif "github.com/barasher/go-exiftool"
 	c "github.com/mproffitt/importmanager/pkg/config"
+	"github.com/mproffitt/importmanager/pkg/journal"
 	"github.com/mproffitt/importmanager/pkg/mime"
 	log "github.com/sirupsen/logrus"
 	m "hg.sr.ht/~dchapes/mode"
 )
 
 // Process start the processing for the given path
-func Process(source string, details *mime.Details, processor *c.Processor) (err error) {
-	log.Infof("Parsing path properties for '%s'", processor.Type)
-	if processor.Properties == nil {
-		(*processor).Properties = make(map[string]string)
-	}
-
-	if strings.Contains(processor.Path, "{{.date}}") {
-		processor.Properties["include-date-directory"] = "true"
-	}
-
-	if strings.Contains(processor.Path, "{{.ext}}") {
-		processor.Properties["extension-directory"] = "true"
-	}
-
-	if strings.Contains(processor.Path, "{{.ucext}}") {
-		processor.Properties["uppercase-extension-directory"] = "true"
-	}
-
+//
+// Arguments:
+//
+// - source    string           The file to process
+// - root      string           The watched path the file was found in
+// - details   *mime.Details    Mime information about the file
+// - processor *config.Processor The processor to execute
+//
+// Return:
+//
+// - error The last error encountered
+func Process(source, root string, details *mime.Details, processor *c.Processor) (err error) {
 	var dest string
-	if dest, err = preProcess(source, processor.Path, details, processor); err != nil {
+	if dest, err = preProcess(source, root, processor.Path, details, processor); err != nil {
 		return
 	}
 
@@ -57,6 +53,8 @@ func Process(source string, details *mime.Details, processor *c.Processor) (err
 		return
 	}
 
+	journal.Target(source, target(source, source, dest, details, processor))
+
 	var final string
 	log.Infof("Checking processor type '%s'", processor.Handler)
 	if c.DefaultHandlers.IsBuiltIn(processor.Handler) {
@@ -72,12 +70,16 @@ func Process(source string, details *mime.Details, processor *c.Processor) (err
 	}
 
 	if final != "" {
+		journal.Destination(source, final)
 		err = postProcess(final, details, processor)
 	}
 	return
 }
 
 func builtIn(source, dest string, details *mime.Details, processor *c.Processor) (final string, err error) {
+	if isDir(source) {
+		return builtInDirectory(source, dest, processor)
+	}
 	switch processor.Handler {
 	case "copy":
 		final, err = pcopy(source, dest, details, processor)
@@ -95,66 +97,93 @@ func builtIn(source, dest string, details *mime.Details, processor *c.Processor)
 
 type properties map[string]interface{}
 
-func preProcess(path, dest string, details *mime.Details, processor *c.Processor) (string, error) {
+// templated Test if a value is needed to render a destination path
+//
+// This is the case when the path uses the placeholder for the value or the
+// property enabling it is set on the processor.
+func templated(format, placeholder, property string, processor *c.Processor) bool {
+	if strings.Contains(format, placeholder) {
+		return true
+	}
+	b, _ := strconv.ParseBool(processor.Properties[property])
+	return b
+}
+
+// preProcess Render the destination directory for a file and create it
+func preProcess(path, root, dest string, details *mime.Details, processor *c.Processor) (string, error) {
+	var err error
+	if dest, err = render(path, path, root, dest, details, processor); err != nil {
+		return "", err
+	}
+
+	if !details.DryRun {
+		if err = os.MkdirAll(dest, 0750); err != nil {
+			return "", err
+		}
+	}
+
+	return dest, nil
+}
+
+// render Render the templated destination directory for a file
+//
+// Dates are read from `from`. This is the file itself unless it has since
+// been moved.
+func render(path, from, root, dest string, details *mime.Details, processor *c.Processor) (string, error) {
 	log.Infof("Triggering preProcessing for '%s'", processor.Type)
 	var p properties = properties{
-		"ext": strings.Replace(details.Extension, ".", "", 1),
+		"ext":     strings.Replace(details.Extension, ".", "", 1),
+		"subpath": "",
 	}
-	for key, value := range processor.Properties {
-		switch strings.ToLower(key) {
-		case "uppercase-extension-directory":
-			if b, _ := strconv.ParseBool(value); !b {
-				continue
-			}
-			var ext string = strings.ToUpper(details.Extension)
-			p["ucext"] = strings.Replace(ext, ".", "", 1)
 
-		case "include-date-directory":
-			if b, _ := strconv.ParseBool(value); !b || details.DryRun {
-				continue
-			}
+	// The directory of the file relative to the watched path. This allows
+	// the source structure to be mirrored into the destination
+	if rel, err := filepath.Rel(root, filepath.Dir(path)); err == nil && rel != "." && !strings.HasPrefix(rel, "..") {
+		p["subpath"] = rel
+	}
 
-			var date string = "2023:09:12 23:34:00+00:00"
-			fi, _ := os.Stat(path)
-			// Default to STAT Modification time
-			date = fi.ModTime().Format("2006-01-02")
+	if templated(dest, "{{.ucext}}", "uppercase-extension-directory", processor) {
+		var ext string = strings.ToUpper(details.Extension)
+		p["ucext"] = strings.Replace(ext, ".", "", 1)
+	}
 
-			// If this is an image, try and use the ExifData
-			if details.Catagory == "image" {
-				if info, err := exifData(path); err == nil {
-					var d string
-					// Default images to CreateDate
-					if v, ok := info["CreateDate"]; ok {
-						d = v.(string)
-					}
+	if templated(dest, "{{.date}}", "include-date-directory", processor) && !details.DryRun {
+		var date string = "2023:09:12 23:34:00+00:00"
+		fi, err := os.Stat(from)
+		if err != nil {
+			return "", fmt.Errorf("Unable to date %s. %s", path, err)
+		}
+		// Default to STAT Modification time
+		date = fi.ModTime().Format("2006-01-02")
 
-					// If we have exif-date in properties, we can try that instead
-					if v, ok := processor.Properties["exif-date"]; ok {
-						d = info[v].(string)
-					}
+		// If this is an image, try and use the ExifData
+		if details.Catagory == "image" {
+			if info, err := ExifReader(from); err == nil {
+				var d string
+				// Default images to CreateDate
+				if v, ok := info["CreateDate"]; ok {
+					d = v.(string)
+				}
 
-					// dont change date until we're sure we have something valid
-					if t, err := time.Parse("2006:01:02 15:04:05-07:00", d); err == nil {
-						date = t.Format("2006-01-02")
-					}
+				// If we have exif-date in properties, we can try that instead
+				if v, ok := processor.Properties["exif-date"]; ok {
+					d = info[v].(string)
+				}
+
+				// dont change date until we're sure we have something valid
+				if t, err := time.Parse("2006:01:02 15:04:05-07:00", d); err == nil {
+					date = t.Format("2006-01-02")
 				}
 			}
-			p["date"] = date
 		}
+		p["date"] = date
 	}
 
 	var err error
-	if dest, err = formatT(dest, p); err != nil {
+	if dest, err = formatPath(dest, p, processor); err != nil {
 		return "", err
 	}
-
-	if !details.DryRun {
-		if err = os.MkdirAll(dest, 0750); err != nil {
-			return "", err
-		}
-	}
-
-	return dest, nil
+	return resolvePath(filepath.Clean(dest), processor), nil
 }
 
 func isDir(path string) bool {
@@ -234,19 +263,14 @@ func postProcess(dest string, details *mime.Details, processor *c.Processor) (er
 		case "setexec":
 			log.Infof("checking setexec %s", v)
 			if b, _ := strconv.ParseBool(v); !b {
-				// We reuse `setexec` property when coming from
-				// `install` - probably other places will too.
+				// A path may be given to make another file
+				// executable instead
 				if _, err := os.Stat(v); err != nil {
 					continue
 				}
 				dest = v
 			}
-
-			var set m.Set
-			if set, err = m.Parse("+x"); err != nil {
-				return
-			}
-			if _, _, err = set.Chmod(dest); err != nil {
+			if err = setExecutable(dest); err != nil {
 				return
 			}
 		}
@@ -254,6 +278,19 @@ func postProcess(dest string, details *mime.Details, processor *c.Processor) (er
 	return
 }
 
+// setExecutable Add the executable bits to a file
+func setExecutable(path string) error {
+	set, err := m.Parse("+x")
+	if err != nil {
+		return err
+	}
+	_, _, err = set.Chmod(path)
+	return err
+}
+
+// formatT Render a path template
+//
+// Values are inserted as they are. Paths are not HTML so nothing is escaped.
 func formatT(format string, args properties) (formatted string, err error) {
 	log.Debugf("Templating '%s' with %+v", format, args)
 	t := template.Must(template.New("").Parse(format))
@@ -264,6 +301,35 @@ func formatT(format string, args properties) (formatted string, err error) {
 	return
 }
 
+// formatPath templates each component of a destination path
+//
+// Rendered components are sanitised for the destination filesystem whilst
+// static components from the configuration are left untouched.
+func formatPath(format string, args properties, processor *c.Processor) (formatted string, err error) {
+	var parts []string = strings.Split(format, "/")
+	for i, part := range parts {
+		if !strings.Contains(part, "{{") {
+			continue
+		}
+		var rendered string
+		if rendered, err = formatT(part, args); err != nil {
+			return
+		}
+		var components []string = strings.Split(rendered, "/")
+		for j := range components {
+			components[j] = sanitise(components[j], "", processor)
+		}
+		parts[i] = strings.Join(components, "/")
+	}
+	formatted = strings.Join(parts, "/")
+	return
+}
+
+// ExifReader Read the exif data of a file
+//
+// Scenario tests replace this to date images without real image data.
+var ExifReader func(path string) (map[string]interface{}, error) = exifData
+
 func exifData(path string) (map[string]interface{}, error) {
 	et, err := exif.NewExiftool()
 	if err != nil {
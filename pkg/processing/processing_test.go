@@ -0,0 +1,72 @@
+package processing
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	c "github.com/mproffitt/importmanager/pkg/config"
+	m "github.com/mproffitt/importmanager/pkg/mime"
+)
+
+// snapshot Copy the properties of a processor for comparison
+func snapshot(processor *c.Processor) map[string]string {
+	var properties map[string]string = make(map[string]string)
+	for k, v := range processor.Properties {
+		properties[k] = v
+	}
+	return properties
+}
+
+func TestRenderDoesNotChangeTheProcessor(t *testing.T) {
+	var (
+		root      string       = t.TempDir()
+		path      string       = filepath.Join(root, "report.pdf")
+		details   *m.Details   = &m.Details{Extension: ".pdf", Catagory: "application"}
+		processor *c.Processor = &c.Processor{Properties: map[string]string{}}
+	)
+	if err := ioutil.WriteFile(path, []byte("content"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	var before map[string]string = snapshot(processor)
+
+	dest, err := render(path, path, root, filepath.Join(root, "{{.ucext}}", "{{.date}}"), details, processor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var expected string = filepath.Join(root, "PDF", time.Now().Format("2006-01-02"))
+	if dest != expected {
+		t.Errorf("expected %s, got %s", expected, dest)
+	}
+	if !reflect.DeepEqual(processor.Properties, before) {
+		t.Errorf("expected properties %v to be unchanged, got %v", before, processor.Properties)
+	}
+}
+
+func TestCopyWithShaCheckDoesNotChangeTheProcessor(t *testing.T) {
+	var (
+		source    string       = filepath.Join(t.TempDir(), "report")
+		dest      string       = filepath.Join(t.TempDir(), "report")
+		details   *m.Details   = &m.Details{}
+		processor *c.Processor = &c.Processor{Handler: "copy", Properties: map[string]string{"compare-sha": "true"}}
+	)
+	for path, content := range map[string]string{source: "new", dest: "old"} {
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var before map[string]string = snapshot(processor)
+
+	final, err := copyWithShaCheck(source, dest, details, processor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Base(final) != "report_1" {
+		t.Errorf("expected the copy to be numbered, got %s", final)
+	}
+	if !reflect.DeepEqual(processor.Properties, before) {
+		t.Errorf("expected properties %v to be unchanged, got %v", before, processor.Properties)
+	}
+}
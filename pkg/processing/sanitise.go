@@ -0,0 +1,249 @@
+package processing
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	c "github.com/mproffitt/importmanager/pkg/config"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// MaxNameLength The longest filename supported by all profiles
+const MaxNameLength = 255
+
+// DefaultReplacement Forbidden characters are replaced with this unless
+// `sanitise-replacement` is set
+const DefaultReplacement = "_"
+
+// profile describes the restrictions of a destination filesystem
+type profile struct {
+	// forbidden returns true if the rune cannot be used in a filename
+	forbidden func(r rune) bool
+
+	// ascii strips diacritics and replaces any remaining non ascii runes
+	ascii bool
+
+	// utf16 measures the name length in UTF-16 code units instead of bytes
+	utf16 bool
+
+	// fold treats names differing only in case as the same file
+	fold bool
+
+	// trailing characters removed from the end of the name
+	trailing string
+
+	// reserved device names which cannot be used as a basename
+	reserved []string
+}
+
+var profiles = map[string]profile{
+	"posix": {
+		forbidden: func(r rune) bool {
+			return r == '/' || r == 0
+		},
+	},
+	"fat": {
+		forbidden: func(r rune) bool {
+			return r < 0x20 || strings.ContainsRune(`<>:"/\|?*`, r)
+		},
+		utf16:    true,
+		fold:     true,
+		trailing: ". ",
+		reserved: []string{
+			"CON", "PRN", "AUX", "NUL",
+			"COM1", "COM2", "COM3", "COM4", "COM5", "COM6", "COM7", "COM8", "COM9",
+			"LPT1", "LPT2", "LPT3", "LPT4", "LPT5", "LPT6", "LPT7", "LPT8", "LPT9",
+		},
+	},
+	"portable": {
+		forbidden: func(r rune) bool {
+			return !(r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("._-", r)))
+		},
+		ascii:    true,
+		fold:     true,
+		trailing: ". ",
+	},
+}
+
+// getProfile Get the sanitisation profile requested by the `sanitise` property
+func getProfile(processor *c.Processor) (s profile, ok bool) {
+	var name string = strings.ToLower(processor.Properties["sanitise"])
+	if name == "" {
+		return
+	}
+	if s, ok = profiles[name]; !ok {
+		log.Errorf("Unknown sanitise profile '%s'. Filenames will not be sanitised", name)
+	}
+	return
+}
+
+// normalise Convert a name to Unicode NFC, or to plain ascii for profiles
+// which require it
+func (s profile) normalise(name string) string {
+	if !s.ascii {
+		return norm.NFC.String(name)
+	}
+	t := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+	if result, _, err := transform.String(t, name); err == nil {
+		return result
+	}
+	return name
+}
+
+// key Get the comparison key used to detect collisions between names
+func (s profile) key(name string) string {
+	name = s.normalise(name)
+	if s.fold {
+		name = strings.ToLower(name)
+	}
+	return name
+}
+
+func (s profile) clean(name, replacement string) string {
+	var b strings.Builder
+	for _, r := range s.normalise(name) {
+		if s.forbidden(r) {
+			b.WriteString(replacement)
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func (s profile) length(name string) int {
+	if s.utf16 {
+		return len(utf16.Encode([]rune(name)))
+	}
+	return len(name)
+}
+
+// truncate shortens name on a rune boundary so that it fits in size
+func (s profile) truncate(name string, size int) string {
+	if size < 0 {
+		size = 0
+	}
+	for s.length(name) > size {
+		_, l := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-l]
+	}
+	return name
+}
+
+// sanitise Clean a filename for the destination filesystem
+//
+// The name is normalised to NFC, forbidden characters are replaced and the
+// name is truncated to the filesystem limit whilst keeping the extension.
+//
+// Arguments:
+//
+// - basename  string      The filename without extension
+// - extension string      The extension, including the leading `.`
+// - processor *Processor  The processor holding the `sanitise` properties
+//
+// Return:
+//
+// - string The sanitised filename
+func sanitise(basename, extension string, processor *c.Processor) string {
+	var s, ok = getProfile(processor)
+	if !ok || basename+extension == "" {
+		return basename + extension
+	}
+
+	var replacement string = DefaultReplacement
+	if v, ok := processor.Properties["sanitise-replacement"]; ok {
+		replacement = v
+	}
+
+	var original string = basename + extension
+	basename, extension = s.clean(basename, replacement), s.clean(extension, replacement)
+	if extension == "" || strings.Trim(extension, s.trailing) == "" {
+		basename, extension = strings.TrimRight(basename+extension, s.trailing), ""
+	} else {
+		extension = strings.TrimRight(extension, s.trailing)
+	}
+
+	for _, r := range s.reserved {
+		if strings.EqualFold(basename, r) {
+			basename = basename + replacement
+			break
+		}
+	}
+
+	// An extension which is too long on its own is shortened as well,
+	// leaving room for at least one character of the basename
+	if s.length(extension) >= MaxNameLength {
+		extension = s.truncate(extension, MaxNameLength-1)
+	}
+	basename = s.truncate(basename, MaxNameLength-s.length(extension))
+	if basename == "" {
+		basename = DefaultReplacement
+	}
+
+	if basename+extension != original {
+		log.Infof("Sanitised filename '%s' to '%s'", original, basename+extension)
+	}
+	return basename + extension
+}
+
+// resolveCollision Find an existing entry in dir which collides with name
+//
+// Entries which only differ by Unicode normalisation, or by case on case
+// insensitive profiles, are treated as the same file. The name of the
+// existing entry is returned so that duplicate handling applies to it.
+func resolveCollision(dir, name string, processor *c.Processor) string {
+	var s, ok = getProfile(processor)
+	if !ok {
+		return name
+	}
+
+	if _, err := os.Lstat(filepath.Join(dir, name)); err == nil {
+		return name
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return name
+	}
+
+	var key string = s.key(name)
+	for _, e := range entries {
+		if s.key(e.Name()) == key {
+			log.Warnf("Name '%s' collides with existing '%s' in %s", name, e.Name(), dir)
+			return e.Name()
+		}
+	}
+	return name
+}
+
+// resolvePath Resolve each component of path against existing entries
+//
+// This prevents parallel trees being created when an existing directory
+// differs only by case or normalisation (e.g. NFD names created by macOS).
+func resolvePath(path string, processor *c.Processor) string {
+	if _, ok := getProfile(processor); !ok {
+		return path
+	}
+
+	var (
+		parts    []string = strings.Split(filepath.Clean(path), string(filepath.Separator))
+		resolved string   = string(filepath.Separator)
+	)
+	if !filepath.IsAbs(path) {
+		resolved = ""
+	}
+	for _, part := range parts {
+		if part == "" {
+			continue
+		}
+		resolved = filepath.Join(resolved, resolveCollision(resolved, part, processor))
+	}
+	return resolved
+}
@@ -0,0 +1,72 @@
+package processing
+
+import (
+	"strings"
+	"testing"
+
+	c "github.com/mproffitt/importmanager/pkg/config"
+)
+
+// sanitised Get a processor using a sanitise profile
+func sanitised(profile string) *c.Processor {
+	return &c.Processor{Properties: map[string]string{"sanitise": profile}}
+}
+
+func TestSanitise(t *testing.T) {
+	var tests = []struct {
+		name      string
+		profile   string
+		basename  string
+		extension string
+		expected  string
+	}{
+		{name: "no profile", profile: "", basename: "a:b", extension: ".txt", expected: "a:b.txt"},
+		{name: "posix keeps colons", profile: "posix", basename: "a:b", extension: ".txt", expected: "a:b.txt"},
+		{name: "fat replaces forbidden characters", profile: "fat", basename: `a:b?c`, extension: ".txt", expected: "a_b_c.txt"},
+		{name: "fat trailing dots and spaces", profile: "fat", basename: "name. ", extension: "", expected: "name"},
+		{name: "fat reserved names", profile: "fat", basename: "con", extension: ".txt", expected: "con_.txt"},
+		{name: "portable strips diacritics", profile: "portable", basename: "café menu", extension: ".pdf", expected: "cafe_menu.pdf"},
+		{name: "nfc normalisation", profile: "posix", basename: "café", extension: ".pdf", expected: "café.pdf"},
+		{name: "long names keep their extension", profile: "posix", basename: strings.Repeat("a", 300), extension: ".pdf", expected: strings.Repeat("a", 251) + ".pdf"},
+		{name: "long extensions are truncated", profile: "posix", basename: "a", extension: "." + strings.Repeat("b", 300), expected: "a." + strings.Repeat("b", 253)},
+		{name: "an empty name is replaced", profile: "fat", basename: "...", extension: "", expected: "_"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var processor *c.Processor = &c.Processor{Properties: map[string]string{}}
+			if tt.profile != "" {
+				processor = sanitised(tt.profile)
+			}
+			var got string = sanitise(tt.basename, tt.extension, processor)
+			if got != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, got)
+			}
+			if len(got) > MaxNameLength {
+				t.Errorf("expected at most %d bytes, got %d", MaxNameLength, len(got))
+			}
+		})
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	var tests = []struct {
+		name     string
+		utf16    bool
+		input    string
+		size     int
+		expected string
+	}{
+		{name: "fits", input: "abc", size: 3, expected: "abc"},
+		{name: "bytes", input: "abcdef", size: 3, expected: "abc"},
+		{name: "rune boundary", input: "aé", size: 2, expected: "a"},
+		{name: "utf16 units", utf16: true, input: "ééé", size: 2, expected: "éé"},
+		{name: "negative size", input: "abc", size: -5, expected: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := (profile{utf16: tt.utf16}).truncate(tt.input, tt.size); got != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package scenario
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// junitSuites The root element of a JUnit report
+type junitSuites struct {
+	XMLName  xml.Name     `xml:"testsuites"`
+	Tests    int          `xml:"tests,attr"`
+	Failures int          `xml:"failures,attr"`
+	Errors   int          `xml:"errors,attr"`
+	Suites   []junitSuite `xml:"testsuite"`
+}
+
+// junitSuite The scenarios of a single file
+type junitSuite struct {
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Errors   int         `xml:"errors,attr"`
+	Time     string      `xml:"time,attr"`
+	Cases    []junitCase `xml:"testcase"`
+	seconds  float64
+}
+
+// junitCase A single scenario
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Error     *junitMessage `xml:"error,omitempty"`
+}
+
+// junitMessage Why a scenario failed or could not be run
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// Failed Whether a scenario did not run cleanly or found differences
+func (r Result) Failed() bool {
+	return r.Error != "" || len(r.Differences) > 0
+}
+
+// Text Write results as a readable report
+//
+// Arguments:
+//
+// - w       io.Writer Where to write the report
+// - results []Result  The results of each scenario
+//
+// Return:
+//
+// - int The number of scenarios which failed
+func Text(w io.Writer, results []Result) (failed int) {
+	var suite string
+	for i, r := range results {
+		if i == 0 || r.Suite != suite {
+			if i > 0 {
+				fmt.Fprintln(w)
+			}
+			suite = r.Suite
+			fmt.Fprintln(w, suite)
+		}
+
+		var state string = "PASS"
+		if r.Failed() {
+			state = "FAIL"
+			failed++
+		}
+		fmt.Fprintf(w, "  %s %s (%.2fs)\n", state, r.Name, r.Duration.Seconds())
+		if r.Error != "" {
+			fmt.Fprintf(w, "       ! %s\n", r.Error)
+		}
+		for _, d := range r.Differences {
+			fmt.Fprintf(w, "       %s\n", d)
+		}
+		if r.Sandbox != "" {
+			fmt.Fprintf(w, "       sandbox: %s\n", r.Sandbox)
+		}
+	}
+	fmt.Fprintf(w, "\n%d scenarios, %d failed\n", len(results), failed)
+	return
+}
+
+// JUnit Write results as a JUnit XML report
+//
+// Each scenario file is a test suite and each scenario a test case.
+// Differences are reported as failures and scenarios which could not be
+// run as errors.
+//
+// Arguments:
+//
+// - w       io.Writer Where to write the report
+// - results []Result  The results of each scenario
+//
+// Return:
+//
+// - int   The number of scenarios which failed
+// - error Any error writing the report
+func JUnit(w io.Writer, results []Result) (failed int, err error) {
+	var report junitSuites = junitSuites{Suites: make([]junitSuite, 0)}
+	for _, r := range results {
+		var n int = len(report.Suites) - 1
+		if n < 0 || report.Suites[n].Name != r.Suite {
+			report.Suites = append(report.Suites, junitSuite{Name: r.Suite})
+			n++
+		}
+		var (
+			suite    *junitSuite = &report.Suites[n]
+			testcase junitCase   = junitCase{
+				Name:      r.Name,
+				ClassName: r.Suite,
+				Time:      fmt.Sprintf("%.3f", r.Duration.Seconds()),
+			}
+		)
+		switch {
+		case r.Error != "":
+			testcase.Error = &junitMessage{Message: r.Error, Text: r.Error}
+			suite.Errors++
+			report.Errors++
+		case len(r.Differences) > 0:
+			testcase.Failure = &junitMessage{
+				Message: fmt.Sprintf("%d differences", len(r.Differences)),
+				Text:    strings.Join(r.Differences, "\n"),
+			}
+			suite.Failures++
+			report.Failures++
+		}
+		if r.Failed() {
+			failed++
+		}
+		suite.Cases = append(suite.Cases, testcase)
+		suite.Tests++
+		suite.seconds += r.Duration.Seconds()
+		suite.Time = fmt.Sprintf("%.3f", suite.seconds)
+		report.Tests++
+	}
+
+	if _, err = io.WriteString(w, xml.Header); err != nil {
+		return
+	}
+	var encoder *xml.Encoder = xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err = encoder.Encode(report); err != nil {
+		return
+	}
+	_, err = io.WriteString(w, "\n")
+	return
+}
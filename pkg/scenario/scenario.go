@@ -0,0 +1,362 @@
+package scenario
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	c "github.com/mproffitt/importmanager/pkg/config"
+	h "github.com/mproffitt/importmanager/pkg/handler"
+	m "github.com/mproffitt/importmanager/pkg/mime"
+	p "github.com/mproffitt/importmanager/pkg/processing"
+	log "github.com/sirupsen/logrus"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Load Read every scenario file in a directory
+//
+// Files ending `.yaml` or `.yml` are read in name order. A single file may
+// also be given.
+//
+// Arguments:
+//
+// - path string The directory holding the scenario files
+//
+// Return:
+//
+// - []*Suite The scenarios of each file
+// - error    Any error reading or decoding a file
+func Load(path string) (suites []*Suite, err error) {
+	var (
+		fi    os.FileInfo
+		files []string = []string{path}
+	)
+	if fi, err = os.Stat(path); err != nil {
+		return
+	}
+	if fi.IsDir() {
+		files = make([]string, 0)
+		for _, pattern := range []string{"*.yaml", "*.yml"} {
+			var matches []string
+			if matches, err = filepath.Glob(filepath.Join(path, pattern)); err != nil {
+				return
+			}
+			files = append(files, matches...)
+		}
+		sort.Strings(files)
+	}
+
+	suites = make([]*Suite, 0, len(files))
+	for _, filename := range files {
+		var s *Suite
+		if s, err = read(filename); err != nil {
+			return
+		}
+		suites = append(suites, s)
+	}
+	if len(suites) == 0 {
+		err = fmt.Errorf("no scenario files found in %s", path)
+	}
+	return
+}
+
+// read Decode a scenario file
+func read(filename string) (s *Suite, err error) {
+	var contents []byte
+	if contents, err = ioutil.ReadFile(filename); err != nil {
+		return
+	}
+	s = &Suite{filename: filename}
+	if err = yaml.UnmarshalStrict(contents, s); err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+	if s.Name == "" {
+		s.Name = strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+	}
+	for i, scenario := range s.Scenarios {
+		if scenario.Name == "" {
+			s.Scenarios[i].Name = fmt.Sprintf("scenario %d", i+1)
+		}
+		for _, input := range scenario.Files {
+			if input.Name == "" || filepath.IsAbs(input.Name) || strings.HasPrefix(filepath.Clean(input.Name), "..") {
+				return nil, fmt.Errorf("%s: %s: input files must be named relative to the watched path", filename, s.Scenarios[i].Name)
+			}
+		}
+	}
+	return
+}
+
+// Run Run each scenario of a suite in its own sandbox
+//
+// The config file is loaded for every scenario and each location it
+// writes to is moved into a new temporary directory. The input files are
+// then created and handled in order by the same code used when watching.
+//
+// Arguments:
+//
+// - options Options How to run the scenarios
+//
+// Return:
+//
+// - []Result The outcome of each scenario
+func (s *Suite) Run(options Options) (results []Result) {
+	var configFile string = c.ConfigFile(options.ConfigFile)
+	if s.Config != "" {
+		configFile = s.Config
+		if !filepath.IsAbs(configFile) {
+			configFile = filepath.Join(filepath.Dir(s.filename), configFile)
+		}
+	}
+
+	results = make([]Result, 0, len(s.Scenarios))
+	for _, scenario := range s.Scenarios {
+		var (
+			start  time.Time = time.Now()
+			result Result    = Result{Suite: s.Name, Name: scenario.Name}
+			root   string
+			err    error
+		)
+		if root, err = ioutil.TempDir("", "importmanager-test-"); err == nil {
+			result.Differences, err = scenario.run(configFile, root, options.Verbose)
+			if options.Keep {
+				result.Sandbox = root
+			} else {
+				os.RemoveAll(root)
+			}
+		}
+		if err != nil {
+			result.Error = err.Error()
+		}
+		result.Duration = time.Since(start)
+		results = append(results, result)
+	}
+	return
+}
+
+// run Handle the inputs of a scenario inside root and compare the outcome
+func (s Scenario) run(configFile, root string, verbose bool) (differences []string, err error) {
+	var (
+		level   log.Level = log.WarnLevel
+		config  *c.Config
+		watched *c.Path
+	)
+	if verbose {
+		level = log.DebugLevel
+	}
+	log.SetLevel(level)
+	if config, err = c.New(configFile, h.Handle); err != nil {
+		return
+	}
+	// Loading the config applies its own log level
+	log.SetLevel(level)
+
+	config.Sandbox(root)
+	if watched, err = s.watched(config, root); err != nil {
+		return
+	}
+	if err = os.MkdirAll(watched.Path, 0750); err != nil {
+		return
+	}
+
+	var (
+		inputs []string = make([]string, 0, len(s.Files))
+		exif   fixtures = make(fixtures)
+	)
+	for _, input := range s.Files {
+		var path string
+		if path, err = input.create(watched.Path); err != nil {
+			return
+		}
+		inputs = append(inputs, path)
+		if input.Exif != nil {
+			exif[path] = make(map[string]interface{})
+			for k, v := range input.Exif {
+				exif[path][k] = v
+			}
+		}
+	}
+
+	var reader func(string) (map[string]interface{}, error) = p.ExifReader
+	p.ExifReader = exif.reader(reader)
+	defer func() {
+		p.ExifReader = reader
+	}()
+
+	var before map[string]os.FileInfo
+	if before, err = files(root, config); err != nil {
+		return
+	}
+
+	differences = make([]string, 0)
+	for _, path := range inputs {
+		var details *m.Details = m.Catagories.FindBestMatchFor(path)
+		if details == nil || details.Type == h.Partial {
+			log.Warnf("Not handling %s. The type is unknown or partial", path)
+			continue
+		}
+		if err := h.Handle(path, *details, *watched, config.CleanupZeroByte); err != nil {
+			differences = append(differences, fmt.Sprintf("! %s could not be handled. %s", display(root, path), err))
+		}
+	}
+
+	var after map[string]os.FileInfo
+	if after, err = files(root, config); err != nil {
+		return
+	}
+	differences = append(differences, s.Expect.compare(root, watched.Path, before, after)...)
+	return
+}
+
+// watched Find the watched path the inputs of a scenario are dropped into
+//
+// The path may be left out when the config only watches one.
+func (s Scenario) watched(config *c.Config, root string) (*c.Path, error) {
+	if s.Path == "" {
+		if len(config.Paths) != 1 {
+			return nil, fmt.Errorf("the config watches %d paths. Set `path` to choose one", len(config.Paths))
+		}
+		return &config.Paths[0], nil
+	}
+	var path string = c.SandboxPath(root, s.Path)
+	for i := range config.Paths {
+		if config.Paths[i].Path == path {
+			return &config.Paths[i], nil
+		}
+	}
+	return nil, fmt.Errorf("%s is not a watched path", s.Path)
+}
+
+// create Write an input file into the watched path
+func (i Input) create(dir string) (path string, err error) {
+	var magic []byte
+	if magic, err = hex.DecodeString(strings.ReplaceAll(i.Magic, " ", "")); err != nil {
+		return "", fmt.Errorf("invalid magic bytes for %s. %s", i.Name, err)
+	}
+
+	var mode uint64 = 0644
+	if i.Mode != "" {
+		if mode, err = strconv.ParseUint(i.Mode, 8, 32); err != nil {
+			return "", fmt.Errorf("invalid mode '%s' for %s", i.Mode, i.Name)
+		}
+	}
+
+	path = filepath.Join(dir, i.Name)
+	if err = os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return
+	}
+	if err = ioutil.WriteFile(path, append(magic, []byte(i.Content)...), os.FileMode(mode)); err != nil {
+		return
+	}
+	// The umask may have removed bits from the requested mode
+	if err = os.Chmod(path, os.FileMode(mode)); err != nil {
+		return
+	}
+	if !i.ModTime.IsZero() {
+		if err = os.Chtimes(path, i.ModTime, i.ModTime); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// reader Read the exif fields of inputs from the fixtures, falling back to
+// the given reader for any other file
+func (f fixtures) reader(fallback func(string) (map[string]interface{}, error)) func(string) (map[string]interface{}, error) {
+	return func(path string) (map[string]interface{}, error) {
+		if fields, ok := f[path]; ok {
+			return fields, nil
+		}
+		return fallback(path)
+	}
+}
+
+// compare List how the sandbox differs from what was expected
+//
+// Expected files must exist with the given mode and deleted files must be
+// gone. Any other file created or removed while handling is also reported.
+func (e Expect) compare(root, watched string, before, after map[string]os.FileInfo) (differences []string) {
+	var accounted map[string]bool = make(map[string]bool)
+	differences = make([]string, 0)
+
+	for _, expected := range e.Files {
+		var path string = c.SandboxPath(root, expected.Path)
+		accounted[path] = true
+
+		fi, ok := after[path]
+		if !ok {
+			differences = append(differences, fmt.Sprintf("- %s is missing", display(root, path)))
+			continue
+		}
+		if expected.Mode == "" {
+			continue
+		}
+		mode, err := strconv.ParseUint(expected.Mode, 8, 32)
+		if err != nil {
+			differences = append(differences, fmt.Sprintf("! invalid mode '%s' expected for %s", expected.Mode, expected.Path))
+			continue
+		}
+		if fi.Mode().Perm() != os.FileMode(mode).Perm() {
+			differences = append(differences, fmt.Sprintf("~ %s has mode %04o, expected %04o", display(root, path), fi.Mode().Perm(), mode))
+		}
+	}
+
+	for _, deleted := range e.Deleted {
+		var path string = filepath.Join(watched, deleted)
+		if filepath.IsAbs(deleted) || strings.HasPrefix(deleted, "~") || strings.HasPrefix(deleted, "$") {
+			path = c.SandboxPath(root, deleted)
+		}
+		accounted[path] = true
+		if _, ok := after[path]; ok {
+			differences = append(differences, fmt.Sprintf("+ %s was not deleted", display(root, path)))
+		}
+	}
+
+	var unexpected []string = make([]string, 0)
+	for path := range after {
+		if _, ok := before[path]; !ok && !accounted[path] {
+			unexpected = append(unexpected, fmt.Sprintf("+ %s was not expected", display(root, path)))
+		}
+	}
+	for path := range before {
+		if _, ok := after[path]; !ok && !accounted[path] {
+			unexpected = append(unexpected, fmt.Sprintf("- %s was deleted", display(root, path)))
+		}
+	}
+	sort.Strings(unexpected)
+	return append(differences, unexpected...)
+}
+
+// files List the files in a sandbox
+//
+// The state, cache and quarantine directories are left out.
+func files(root string, config *c.Config) (found map[string]os.FileInfo, err error) {
+	var skip []string = []string{config.StateDirectory, config.CacheDirectory, config.Quarantine}
+	found = make(map[string]os.FileInfo)
+	err = filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			for _, s := range skip {
+				if path == s {
+					return filepath.SkipDir
+				}
+			}
+			return nil
+		}
+		found[path] = fi
+		return nil
+	})
+	return
+}
+
+// display Show a path in the sandbox as it would be outside of it
+func display(root, path string) string {
+	return strings.TrimPrefix(path, root)
+}
@@ -0,0 +1,71 @@
+package scenario
+
+import (
+	"time"
+)
+
+// Suite A file of scenarios run against the same config file
+type Suite struct {
+	Name      string     `yaml:"name"`
+	Config    string     `yaml:"config"`
+	Scenarios []Scenario `yaml:"scenarios"`
+	filename  string
+}
+
+// Scenario Files dropped into a watched path and what should become of them
+type Scenario struct {
+	Name   string  `yaml:"name"`
+	Path   string  `yaml:"path"`
+	Files  []Input `yaml:"files"`
+	Expect Expect  `yaml:"expect"`
+}
+
+// Input A file created in the watched path before it is handled
+//
+// Magic is written first as hex encoded bytes, followed by Content. Exif
+// fields are given to the handler in place of reading the file.
+type Input struct {
+	Name    string            `yaml:"name"`
+	Content string            `yaml:"content"`
+	Magic   string            `yaml:"magic"`
+	ModTime time.Time         `yaml:"mtime"`
+	Mode    string            `yaml:"mode"`
+	Exif    map[string]string `yaml:"exif"`
+}
+
+// Expect The state of the sandbox once every input has been handled
+//
+// Deleted paths which are relative are taken from the watched path.
+type Expect struct {
+	Files   []Expected `yaml:"files"`
+	Deleted []string   `yaml:"deleted"`
+}
+
+// Expected A file which should exist, optionally with the given mode
+type Expected struct {
+	Path string `yaml:"path"`
+	Mode string `yaml:"mode"`
+}
+
+// fixtures Exif fields of input files, keyed by path
+type fixtures map[string]map[string]interface{}
+
+// Options How scenarios are run
+type Options struct {
+	ConfigFile string
+	Keep       bool
+	Verbose    bool
+}
+
+// Result The outcome of a single scenario
+//
+// A scenario passes when it ran without Error and no Differences were
+// found. Sandbox is only kept when Options.Keep is set.
+type Result struct {
+	Suite       string
+	Name        string
+	Duration    time.Duration
+	Differences []string
+	Error       string
+	Sandbox     string
+}